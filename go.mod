@@ -6,7 +6,9 @@ toolchain go1.23.2
 
 require (
 	github.com/charles-haynes/munkres v0.0.0-20191008174651-55d467190535
+	github.com/google/uuid v1.6.0
 	github.com/pkg/errors v0.9.1
+	golang.org/x/image v0.19.0
 	go.viam.com/rdk v0.55.0
 	go.viam.com/test v1.2.4
 	go.viam.com/utils v0.1.116
@@ -74,7 +76,6 @@ require (
 	github.com/google/flatbuffers v2.0.6+incompatible // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/google/s2a-go v0.1.8 // indirect
-	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.3 // indirect
 	github.com/googleapis/gax-go/v2 v2.13.0 // indirect
 	github.com/gorilla/securecookie v1.1.1 // indirect
@@ -149,7 +150,6 @@ require (
 	go4.org/unsafe/assume-no-moving-gc v0.0.0-20230525183740-e7c30c78aeb2 // indirect
 	golang.org/x/crypto v0.28.0 // indirect
 	golang.org/x/exp v0.0.0-20240904232852-e7e105dedf7e // indirect
-	golang.org/x/image v0.19.0 // indirect
 	golang.org/x/mod v0.21.0 // indirect
 	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/oauth2 v0.22.0 // indirect
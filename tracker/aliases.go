@@ -0,0 +1,55 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains methods that map the raw labels of the detectors to canonical classes
+package tracker
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	objdet "go.viam.com/rdk/vision/objectdetection"
+)
+
+// aliasedDetection is a detection renamed to its canonical class, that remembers the label given by the detector
+type aliasedDetection struct {
+	objdet.Detection
+	rawLabel string
+}
+
+// newLabelAliases checks the aliases of the config and returns them with lowercase raw labels
+func newLabelAliases(aliases map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(aliases))
+	for raw, class := range aliases {
+		class = strings.ToLower(class)
+		if class == "" || strings.Contains(class, "_") {
+			return nil, errors.Errorf("alias of label %v must be a non-empty class name without underscores", raw)
+		}
+		out[strings.ToLower(raw)] = class
+	}
+	return out, nil
+}
+
+// aliasLabels renames the detections whose raw label (case insensitive) has an alias to their canonical class
+func (t *myTracker) aliasLabels(detections []objdet.Detection) []objdet.Detection {
+	if len(t.labelAliases) == 0 {
+		return detections
+	}
+	out := make([]objdet.Detection, 0, len(detections))
+	for _, d := range detections {
+		if class, ok := t.labelAliases[strings.ToLower(d.Label())]; ok {
+			d = &aliasedDetection{
+				Detection: objdet.NewDetection(*d.BoundingBox(), d.Score(), class),
+				rawLabel:  d.Label(),
+			}
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// withRawLabel returns the detection with the raw label of from, if from was aliased
+func withRawLabel(d, from objdet.Detection) objdet.Detection {
+	if aliased, ok := from.(*aliasedDetection); ok {
+		return &aliasedDetection{Detection: d, rawLabel: aliased.rawLabel}
+	}
+	return d
+}
@@ -0,0 +1,110 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains methods that describe the appearance of the detections, used to re-identify tracks
+package tracker
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	hueBins        = 8
+	saturationBins = 4
+	valueBins      = 4
+	// at most maxHistogramSamples x maxHistogramSamples pixels are sampled from each crop
+	maxHistogramSamples = 64
+	// weight of the newest frame in the running average of a track's appearance
+	appearanceUpdateRate = 0.2
+)
+
+// Defaults for the appearance attributes
+var (
+	DefaultAppearanceWeight            = 0.0
+	DefaultAppearanceRecoveryThreshold = 0.8
+)
+
+// describeTracks computes the appearance of each track from the crop of its bounding box
+func describeTracks(tracks []*track, img image.Image) []*track {
+	for _, tr := range tracks {
+		tr.appearance = colorHistogram(cropImageFromDet(img, tr.Det))
+	}
+	return tracks
+}
+
+// colorHistogram returns the normalized HSV histogram of an image
+func colorHistogram(img image.Image) []float64 {
+	hist := make([]float64, hueBins*saturationBins*valueBins)
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return hist
+	}
+	stepX := max(1, bounds.Dx()/maxHistogramSamples)
+	stepY := max(1, bounds.Dy()/maxHistogramSamples)
+	total := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			h, s, v := rgbToHSV(float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff)
+			hIdx := min(int(h*hueBins), hueBins-1)
+			sIdx := min(int(s*saturationBins), saturationBins-1)
+			vIdx := min(int(v*valueBins), valueBins-1)
+			hist[(hIdx*saturationBins+sIdx)*valueBins+vIdx]++
+			total++
+		}
+	}
+	for i := range hist {
+		hist[i] /= total
+	}
+	return hist
+}
+
+// rgbToHSV converts a color with channels between 0 and 1 to hue, saturation and value, all between 0 and 1
+func rgbToHSV(r, g, b float64) (float64, float64, float64) {
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	delta := maxC - minC
+	var h, s float64
+	if maxC > 0 {
+		s = delta / maxC
+	}
+	if delta > 0 {
+		switch maxC {
+		case r:
+			h = math.Mod((g-b)/delta, 6)
+		case g:
+			h = (b-r)/delta + 2
+		default:
+			h = (r-g)/delta + 4
+		}
+		h /= 6
+		if h < 0 {
+			h++
+		}
+	}
+	return h, s, maxC
+}
+
+// appearanceSimilarity returns the Bhattacharyya coefficient of two normalized histograms,
+// 1 for identical histograms and 0 for histograms that do not overlap at all.
+func appearanceSimilarity(h1, h2 []float64) float64 {
+	if len(h1) != len(h2) {
+		return 0
+	}
+	sum := 0.0
+	for i := range h1 {
+		sum += math.Sqrt(h1[i] * h2[i])
+	}
+	return math.Min(sum, 1)
+}
+
+// updateAppearance returns the running average of a track's appearance with a newly observed one
+func updateAppearance(old, observed []float64) []float64 {
+	if old == nil || len(old) != len(observed) {
+		return observed
+	}
+	out := make([]float64, len(old))
+	for i := range old {
+		out[i] = (1-appearanceUpdateRate)*old[i] + appearanceUpdateRate*observed[i]
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the concurrent and batched calls to the classifier
+package tracker
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/services/vision"
+	"go.viam.com/rdk/vision/classification"
+	objdet "go.viam.com/rdk/vision/objectdetection"
+)
+
+// Defaults for the calls to the classifier: the number of crops classified at the same time,
+// and the time (in seconds) after which a call is abandoned
+var (
+	DefaultClassifierConcurrency = 1
+	DefaultClassifierTimeout     = 5.0
+)
+
+// classifyDetections classifies the crop of each detection and returns the classifications in the same order.
+// The classification of a crop that failed or timed out is nil, the other crops are still classified.
+func (t *myTracker) classifyDetections(ctx context.Context, dets []objdet.Detection, img image.Image) []classification.Classification {
+	if len(dets) == 0 {
+		return nil
+	}
+	if t.classifierMosaic {
+		return t.classifyMosaic(ctx, dets, img)
+	}
+	results := make([]classification.Classification, len(dets))
+	runPool(ctx, len(dets), t.classifierConcurrency, t.classifierTimeout, func(callCtx context.Context, i int) {
+		if c, ok := classifyCrop(callCtx, t.cropper.crop(img, dets[i]), t.pizzaClassifier, t.logger); ok {
+			results[i] = c
+		}
+	})
+	return results
+}
+
+// runPool calls do for the jobs 0 to n-1, with at most concurrency calls at the same time. Each call gets its
+// own context, abandoned after the timeout unless it is 0. It returns once all the calls returned.
+func runPool(ctx context.Context, n, concurrency int, timeout time.Duration, do func(callCtx context.Context, i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(concurrency, 1), n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				callCtx, cancel := callContext(ctx, timeout)
+				do(callCtx, i)
+				cancel()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// classifierContext returns the context of one call to the classifier, with the configured timeout
+func (t *myTracker) classifierContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return callContext(ctx, t.classifierTimeout)
+}
+
+// callContext returns the context of one call to a model, abandoned after the timeout unless it is 0
+func callContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// buildMosaic tiles the crops of the detections in a grid, and returns the grid image and where each crop is in it
+func buildMosaic(dets []objdet.Detection, img image.Image, c *cropper) (*image.RGBA, []image.Rectangle) {
+	crops := make([]image.Image, len(dets))
+	cellW, cellH := 0, 0
+	for i, d := range dets {
+		crops[i] = c.crop(img, d)
+		cellW = max(cellW, crops[i].Bounds().Dx())
+		cellH = max(cellH, crops[i].Bounds().Dy())
+	}
+	cols := int(math.Ceil(math.Sqrt(float64(len(dets)))))
+	rows := (len(dets) + cols - 1) / cols
+	mosaic := image.NewRGBA(image.Rect(0, 0, cols*cellW, rows*cellH))
+	tiles := make([]image.Rectangle, len(dets))
+	for i, crop := range crops {
+		origin := image.Pt((i%cols)*cellW, (i/cols)*cellH)
+		tiles[i] = image.Rectangle{Min: origin, Max: origin.Add(crop.Bounds().Size())}
+		draw.Draw(mosaic, tiles[i], crop, crop.Bounds().Min, draw.Src)
+	}
+	return mosaic, tiles
+}
+
+// checkMosaicClassifier returns an error if the classifier cannot be used in mosaic mode, because it does not
+// return detections. A plain classification model can only classify the crops one by one.
+func checkMosaicClassifier(ctx context.Context, classifier vision.Service) error {
+	props, err := classifier.GetProperties(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get the properties of classifier %v", classifier.Name())
+	}
+	if !props.DetectionSupported {
+		return errors.Errorf("classifier_mosaic needs a classifier that returns detections, %v does not", classifier.Name())
+	}
+	return nil
+}
+
+// classifyMosaic sends all the crops to the classifier as one mosaic image, in one call. The classifier must
+// return detections on the mosaic: each crop gets the label of the highest scoring detection centered in its tile.
+func (t *myTracker) classifyMosaic(ctx context.Context, dets []objdet.Detection, img image.Image) []classification.Classification {
+	results := make([]classification.Classification, len(dets))
+	mosaic, tiles := buildMosaic(dets, img, t.cropper)
+	callCtx, cancel := t.classifierContext(ctx)
+	defer cancel()
+	found, err := t.pizzaClassifier.Detections(callCtx, mosaic, nil)
+	if err != nil {
+		t.logger.Warnf("error classifying mosaic of %d detections: %v", len(dets), err)
+		return results
+	}
+	for _, d := range found {
+		center := boxCenter(d.BoundingBox())
+		for i, tile := range tiles {
+			if center.x < float64(tile.Min.X) || center.x >= float64(tile.Max.X) ||
+				center.y < float64(tile.Min.Y) || center.y >= float64(tile.Max.Y) {
+				continue
+			}
+			if results[i] == nil || d.Score() > results[i].Score() {
+				results[i] = classification.NewClassification(d.Score(), d.Label())
+			}
+			break
+		}
+	}
+	return results
+}
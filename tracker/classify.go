@@ -9,42 +9,58 @@ import (
 
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/services/vision"
+	"go.viam.com/rdk/vision/classification"
 	objdet "go.viam.com/rdk/vision/objectdetection"
 )
 
 // method will take a slice of tracks and return a slice of tracks.
 // The only difference is that the track will now include the detection classification
-func classifyTracks(ctx context.Context, tracks []*track, img image.Image, classifier vision.Service, logger logging.Logger) []*track {
-	if classifier == nil {
+func (t *myTracker) classifyTracks(ctx context.Context, tracks []*track, img image.Image) []*track {
+	if t.pizzaClassifier == nil {
 		return tracks
 	}
 
+	dets := make([]objdet.Detection, 0, len(tracks))
 	for _, tr := range tracks {
-		cropped := cropImageFromDet(img, tr.Det)
-		out, err := classifier.Classifications(ctx, cropped, 1, nil)
-		if err != nil || len(out) < 1 {
-			// if there is an error, just skip the classification
-			logger.Warnf("error classifying detection: %v", err)
-			continue
-		}
-		sortedOut, err := out.TopN(1)
-		if err != nil {
-			logger.Warnf("error sorting classifications: %v", err)
-			continue
+		dets = append(dets, tr.Det)
+	}
+	for i, c := range t.classifyDetections(ctx, dets, img) {
+		if c != nil {
+			tracks[i].detClassification = c
 		}
-		tr.detClassification = sortedOut[0]
 	}
 	return tracks
 }
 
-// empty bounding box implies no crop
+// classifyCrop returns the top classification of a crop, if the classifier gave one
+func classifyCrop(ctx context.Context, cropped image.Image, classifier vision.Service,
+	logger logging.Logger) (classification.Classification, bool) {
+	out, err := classifier.Classifications(ctx, cropped, 1, nil)
+	if err != nil || len(out) < 1 {
+		// if there is an error, just skip the classification
+		logger.Warnf("error classifying detection: %v", err)
+		return nil, false
+	}
+	sortedOut, err := out.TopN(1)
+	if err != nil {
+		logger.Warnf("error sorting classifications: %v", err)
+		return nil, false
+	}
+	return sortedOut[0], true
+}
+
+// empty bounding box implies no crop, the box is clamped to the image
 func cropImageFromDet(img image.Image, det objdet.Detection) image.Image {
 	bb := det.BoundingBox()
 	if bb.Max.X == 0 || bb.Max.Y == 0 {
 		return img
 	}
+	region := bb.Intersect(img.Bounds())
+	if region.Empty() {
+		return img
+	}
 
-	croppedImg := image.NewRGBA(image.Rect(0, 0, bb.Dx(), bb.Dy()))
-	draw.Draw(croppedImg, croppedImg.Bounds(), img, bb.Min, draw.Src)
+	croppedImg := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	draw.Draw(croppedImg, croppedImg.Bounds(), img, region.Min, draw.Src)
 	return croppedImg
 }
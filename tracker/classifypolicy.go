@@ -0,0 +1,132 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the policies deciding when the tracks are classified
+package tracker
+
+import (
+	"context"
+	"image"
+
+	"github.com/pkg/errors"
+	objdet "go.viam.com/rdk/vision/objectdetection"
+)
+
+// Classify policies, as given in the config
+const (
+	// ClassifyEveryFrame classifies every detection of every frame, before matching
+	ClassifyEveryFrame = "every_frame"
+	// ClassifyOnNewTrack classifies a track once, when it starts
+	ClassifyOnNewTrack = "on_new_track"
+	// ClassifyOnStable classifies a track once, when it is confirmed
+	ClassifyOnStable = "on_stable"
+	// ClassifyEveryNFrames classifies a track again once it was seen in N frames since its last classification
+	ClassifyEveryNFrames = "every_n_frames"
+	// ClassifyOnBoxChange classifies a track again once its box moved or changed size since its last classification
+	ClassifyOnBoxChange = "on_box_change"
+)
+
+// Defaults for the classify policies: the number of frames between classifications with ClassifyEveryNFrames,
+// and the change of box (1 - IoU with the box at the last classification) with ClassifyOnBoxChange
+var (
+	DefaultClassifyEveryNFrames = 10
+	DefaultClassifyIOUDelta     = 0.3
+)
+
+// classifyPolicy decides which tracks are classified after matching. A nil policy is ClassifyEveryFrame.
+type classifyPolicy struct {
+	mode     string
+	everyN   int
+	iouDelta float64
+}
+
+func newClassifyPolicy(mode string, everyN int, iouDelta float64) (*classifyPolicy, error) {
+	switch mode {
+	case "":
+		mode = ClassifyEveryFrame
+	case ClassifyEveryFrame, ClassifyOnNewTrack, ClassifyOnStable, ClassifyEveryNFrames, ClassifyOnBoxChange:
+	default:
+		return nil, errors.Errorf("unknown classify_policy %q, must be %q, %q, %q, %q or %q", mode,
+			ClassifyEveryFrame, ClassifyOnNewTrack, ClassifyOnStable, ClassifyEveryNFrames, ClassifyOnBoxChange)
+	}
+	if everyN < 1 {
+		return nil, errors.New("classify_every_n_frames must be at least 1")
+	}
+	if iouDelta < 0 || iouDelta > 1 {
+		return nil, errors.New("classify_iou_delta must be between 0.0 and 1.0")
+	}
+	return &classifyPolicy{mode: mode, everyN: everyN, iouDelta: iouDelta}, nil
+}
+
+// everyFrame returns whether the detections are classified before matching, on every frame
+func (p *classifyPolicy) everyFrame() bool {
+	return p == nil || p.mode == ClassifyEveryFrame
+}
+
+// classifiesOnce returns whether the policy classifies each track only once
+func (p *classifyPolicy) classifiesOnce() bool {
+	return p != nil && (p.mode == ClassifyOnNewTrack || p.mode == ClassifyOnStable)
+}
+
+// checkTransitions rejects the split rules when each track is classified only once: the classification
+// of a track then never changes, so they would never apply
+func (p *classifyPolicy) checkTransitions(rules []ClassificationTransition) error {
+	if !p.classifiesOnce() {
+		return nil
+	}
+	for _, rule := range rules {
+		if rule.Action == TransitionSplit {
+			return errors.Errorf("classification transition from %v to %v cannot split tracks with classify_policy %q, "+
+				"which classifies each track once", rule.From, rule.To, p.mode)
+		}
+	}
+	return nil
+}
+
+// due returns whether the new detection must be classified on this frame, given the track it was matched
+// with (nil if it starts a new track). It is called before the track is updated with the detection.
+func (p *classifyPolicy) due(old, next *track) bool {
+	if old == nil {
+		return p.mode != ClassifyOnStable
+	}
+	neverClassified := len(old.classVotes) == 0
+	switch p.mode {
+	case ClassifyOnNewTrack:
+		return neverClassified
+	case ClassifyOnStable:
+		confirmed := old.isStable() || (old.state == trackTentative && old.persistenceCount+1 >= old.persistenceLimit)
+		return neverClassified && confirmed
+	case ClassifyEveryNFrames:
+		return neverClassified || old.framesSinceClassified+1 >= p.everyN
+	case ClassifyOnBoxChange:
+		return neverClassified || 1-boxIOU(next.Det.BoundingBox(), &old.classifiedBox) > p.iouDelta
+	default:
+		return false
+	}
+}
+
+// classifyDue classifies the new detections that the policy asks for, after matching and before the tracks
+// are updated, so that their classifications are voted on and can split the tracks they were matched with.
+// The other tracks keep their last classification.
+func (t *myTracker) classifyDue(ctx context.Context, matches []int, oldDets, newDets []*track, img image.Image) {
+	if t.pizzaClassifier == nil || t.classifyPolicy.everyFrame() {
+		return
+	}
+	matched := make(map[int]*track, len(matches))
+	for oldIdx, newIdx := range matches {
+		if newIdx >= 0 && newIdx < len(newDets) && oldIdx < len(oldDets) {
+			matched[newIdx] = oldDets[oldIdx]
+		}
+	}
+	var due []*track
+	var dets []objdet.Detection
+	for i, next := range newDets {
+		if t.classifyPolicy.due(matched[i], next) {
+			due = append(due, next)
+			dets = append(dets, next.Det)
+		}
+	}
+	for i, c := range t.classifyDetections(ctx, dets, img) {
+		if c != nil {
+			due[i].detClassification = c
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the cost functions used to associate tracks with new detections.
+package tracker
+
+import (
+	"image"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Names of the available cost functions, as given in the config
+const (
+	IOUCostName            = "iou"
+	GIOUCostName           = "giou"
+	DIOUCostName           = "diou"
+	CIOUCostName           = "ciou"
+	CenterDistanceCostName = "center_distance"
+	DefaultCostFunction    = IOUCostName
+)
+
+// CostFunction computes the cost of associating the predicted bounding box of a track
+// with a detected bounding box. Costs are between -1 (identical boxes) and 0, and a cost of 0
+// means the two boxes cannot be associated.
+type CostFunction interface {
+	Cost(predicted, detected *image.Rectangle) float64
+}
+
+// tooFar returns whether the centers of 2 rectangles are further apart than their size (see CenterDistance).
+// The costs that can match boxes that do not overlap give such boxes a cost of 0, so they are never associated.
+func tooFar(r1, r2 *image.Rectangle) bool {
+	return CenterDistance(r1, r2) > 1
+}
+
+type iouCost struct{}
+
+func (iouCost) Cost(predicted, detected *image.Rectangle) float64 {
+	return -IOU(predicted, detected)
+}
+
+type giouCost struct{}
+
+func (giouCost) Cost(predicted, detected *image.Rectangle) float64 {
+	if tooFar(predicted, detected) {
+		return 0
+	}
+	return -(GIOU(predicted, detected) + 1) / 2
+}
+
+type diouCost struct{}
+
+func (diouCost) Cost(predicted, detected *image.Rectangle) float64 {
+	if tooFar(predicted, detected) {
+		return 0
+	}
+	return -(DIOU(predicted, detected) + 1) / 2
+}
+
+type ciouCost struct{}
+
+func (ciouCost) Cost(predicted, detected *image.Rectangle) float64 {
+	if tooFar(predicted, detected) {
+		return 0
+	}
+	return -math.Max(0, (CIOU(predicted, detected)+1)/2)
+}
+
+type centerDistanceCost struct{}
+
+func (centerDistanceCost) Cost(predicted, detected *image.Rectangle) float64 {
+	return -math.Max(0, 1-CenterDistance(predicted, detected))
+}
+
+// NewCostFunction returns the cost function with the given name. An empty name returns the default.
+func NewCostFunction(name string) (CostFunction, error) {
+	switch name {
+	case "", IOUCostName:
+		return iouCost{}, nil
+	case GIOUCostName:
+		return giouCost{}, nil
+	case DIOUCostName:
+		return diouCost{}, nil
+	case CIOUCostName:
+		return ciouCost{}, nil
+	case CenterDistanceCostName:
+		return centerDistanceCost{}, nil
+	default:
+		return nil, errors.Errorf("unknown cost function %q, must be one of %q, %q, %q, %q or %q",
+			name, IOUCostName, GIOUCostName, DIOUCostName, CIOUCostName, CenterDistanceCostName)
+	}
+}
+
+func area(r image.Rectangle) float64 {
+	return float64(r.Dx() * r.Dy())
+}
+
+// overlap returns the intersection area, the union area and the smallest enclosing rectangle of 2 rectangles
+func overlap(r1, r2 *image.Rectangle) (float64, float64, image.Rectangle) {
+	inter := area(r1.Intersect(*r2))
+	union := area(*r1) + area(*r2) - inter
+	return inter, union, r1.Union(*r2)
+}
+
+// squaredCenterDistance returns the squared distance between the centers of 2 rectangles
+func squaredCenterDistance(r1, r2 *image.Rectangle) float64 {
+	dx := float64(r1.Min.X+r1.Max.X-r2.Min.X-r2.Max.X) / 2
+	dy := float64(r1.Min.Y+r1.Max.Y-r2.Min.Y-r2.Max.Y) / 2
+	return dx*dx + dy*dy
+}
+
+// GIOU returns the generalized intersection over union of 2 rectangles, between -1 and 1.
+// Unlike IOU, it keeps decreasing as non-overlapping rectangles move apart.
+func GIOU(r1, r2 *image.Rectangle) float64 {
+	inter, union, enclosing := overlap(r1, r2)
+	if union <= 0 || area(enclosing) <= 0 {
+		return -1
+	}
+	return inter/union - (area(enclosing)-union)/area(enclosing)
+}
+
+// DIOU returns the distance intersection over union of 2 rectangles, between -1 and 1.
+// It penalizes the IOU with the distance between the centers, relative to the enclosing rectangle.
+func DIOU(r1, r2 *image.Rectangle) float64 {
+	inter, union, enclosing := overlap(r1, r2)
+	diag := float64(enclosing.Dx()*enclosing.Dx() + enclosing.Dy()*enclosing.Dy())
+	if union <= 0 || diag <= 0 {
+		return -1
+	}
+	return inter/union - squaredCenterDistance(r1, r2)/diag
+}
+
+// CIOU returns the complete intersection over union of 2 rectangles.
+// It adds a penalty for the difference in aspect ratio to DIOU.
+func CIOU(r1, r2 *image.Rectangle) float64 {
+	diou := DIOU(r1, r2)
+	if r1.Dy() == 0 || r2.Dy() == 0 {
+		return diou
+	}
+	inter, union, _ := overlap(r1, r2)
+	iou := inter / union
+	angle := math.Atan(float64(r1.Dx())/float64(r1.Dy())) - math.Atan(float64(r2.Dx())/float64(r2.Dy()))
+	v := 4 / (math.Pi * math.Pi) * angle * angle
+	if v == 0 {
+		return diou
+	}
+	alpha := v / (1 - iou + v)
+	return diou - alpha*v
+}
+
+// CenterDistance returns the distance between the centers of 2 rectangles, normalized by
+// the average diagonal of the rectangles. Rectangles whose centers are further apart than
+// their size return a distance above 1.
+func CenterDistance(r1, r2 *image.Rectangle) float64 {
+	diag1 := math.Hypot(float64(r1.Dx()), float64(r1.Dy()))
+	diag2 := math.Hypot(float64(r2.Dx()), float64(r2.Dy()))
+	norm := (diag1 + diag2) / 2
+	if norm <= 0 {
+		return math.Inf(1)
+	}
+	return math.Sqrt(squaredCenterDistance(r1, r2)) / norm
+}
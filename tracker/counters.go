@@ -0,0 +1,189 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the persistence of the class counters across restarts and their daily reset
+package tracker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// moduleDataEnv is the environment variable giving the data directory of the module
+const moduleDataEnv = "VIAM_MODULE_DATA"
+
+// counterSaveInterval is the minimum time between two writes of the class counters while tracking
+var counterSaveInterval = time.Second
+
+// counterState is the content of the state file of the class counters
+type counterState struct {
+	// Counters is the number of the latest track of each class
+	Counters  map[string]int `json:"counters"`
+	LastReset time.Time      `json:"last_reset"`
+}
+
+// dailyTime is a local time of the day, HH:MM
+type dailyTime struct {
+	hour, minute int
+}
+
+func parseDailyTime(s string) (*dailyTime, error) {
+	ts, err := time.Parse("15:04", s)
+	if err != nil {
+		return nil, errors.Errorf("counter_reset_time %q must be a local time of the form HH:MM", s)
+	}
+	return &dailyTime{hour: ts.Hour(), minute: ts.Minute()}, nil
+}
+
+// latest returns the latest time before now at which it was this time of the day
+func (d *dailyTime) latest(now time.Time) time.Time {
+	ts := time.Date(now.Year(), now.Month(), now.Day(), d.hour, d.minute, 0, 0, now.Location())
+	if now.Before(ts) {
+		ts = ts.AddDate(0, 0, -1)
+	}
+	return ts
+}
+
+// counterStatePath returns the path of the state file. A relative file name is in the data directory of the module.
+func counterStatePath(file, resourceName string) (string, error) {
+	if file == "" {
+		file = resourceName + "_counters.json"
+	}
+	if filepath.IsAbs(file) {
+		return file, nil
+	}
+	dir := os.Getenv(moduleDataEnv)
+	if dir == "" {
+		return "", errors.Errorf("%v is not set, counter_state_file must be an absolute path", moduleDataEnv)
+	}
+	return filepath.Join(dir, file), nil
+}
+
+// loadCounters restores the class counters from the state file, if there is one,
+// and resets them if a daily reset was missed while the module was stopped
+func (t *myTracker) loadCounters(now time.Time) error {
+	t.countersReset = now
+	if t.counterStateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(t.counterStateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to read counter state file %v", t.counterStateFile)
+	}
+	var state counterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return errors.Wrapf(err, "unable to parse counter state file %v", t.counterStateFile)
+	}
+	if state.Counters != nil {
+		t.classCounter = state.Counters
+	}
+	if !state.LastReset.IsZero() {
+		t.countersReset = state.LastReset
+	}
+	t.resetCountersIfDue(now)
+	return nil
+}
+
+// resetCountersIfDue starts the counters from zero again if the reset time of the day passed since the last reset
+func (t *myTracker) resetCountersIfDue(now time.Time) {
+	if t.counterResetTime == nil || !t.countersReset.Before(t.counterResetTime.latest(now)) {
+		return
+	}
+	t.classCounter = make(map[string]int)
+	t.countersReset = now
+	t.countersDirty = true
+	t.logger.Infof("class counters were reset")
+}
+
+// encodeCounters returns the class counters to write to the state file if they changed since the last write.
+// Unless force or a track number was issued since the last write, the counters are written at most once per
+// counterSaveInterval. It is called with the tracks lock held, the write itself is done by saveCounters once
+// the lock is released.
+func (t *myTracker) encodeCounters(now time.Time, force bool) []byte {
+	if t.counterStateFile == "" || !t.countersDirty {
+		return nil
+	}
+	if !force && !t.countersIssued && now.Sub(t.countersSavedAt) < counterSaveInterval {
+		return nil
+	}
+	data, err := json.Marshal(counterState{Counters: t.classCounter, LastReset: t.countersReset})
+	if err != nil {
+		t.logger.Warnf("unable to encode class counters. got err: %s", err)
+		return nil
+	}
+	t.countersDirty, t.countersIssued = false, false
+	t.countersSavedAt = now
+	return data
+}
+
+// saveCounters writes the encoded class counters to the state file. If it fails, the counters are written again
+// on the next frame.
+func (t *myTracker) saveCounters(data []byte) {
+	if data == nil {
+		return
+	}
+	if err := writeFileAtomic(t.counterStateFile, data); err != nil {
+		t.logger.Warnf("unable to save class counters. got err: %s", err)
+		t.tracksMutex.Lock()
+		t.countersDirty, t.countersIssued = true, true
+		t.tracksMutex.Unlock()
+	}
+}
+
+// flushCounters writes the class counters to the state file if they changed since the last write
+func (t *myTracker) flushCounters() {
+	t.tracksMutex.Lock()
+	data := t.encodeCounters(time.Now(), true)
+	t.tracksMutex.Unlock()
+	t.saveCounters(data)
+}
+
+// useCounterStateFile changes the state file of the class counters on Reconfigure. The counters are loaded
+// from the new file if it exists, otherwise the current counters are written to it.
+func (t *myTracker) useCounterStateFile(path string, now time.Time) error {
+	t.tracksMutex.Lock()
+	defer t.tracksMutex.Unlock()
+	if path == t.counterStateFile {
+		return nil
+	}
+	t.counterStateFile = path
+	// the first file is loaded by newTracker
+	if path == "" || t.countersReset.IsZero() {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		t.countersDirty, t.countersIssued = true, true
+		return nil
+	}
+	return t.loadCounters(now)
+}
+
+// writeFileAtomic writes the data to a temporary file next to path and renames it,
+// so that the file at path is either the old or the new content, even after a crash
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
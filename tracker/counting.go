@@ -0,0 +1,227 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the counter of the tracks crossing lines of the image
+package tracker
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/vision/classification"
+)
+
+// Directions in which a track can cross a counting line. Looking from the start of the line to its end,
+// a track goes "in" when it crosses from the left of the line to its right, and "out" otherwise.
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// CountingLine is a line of the image across which the stable tracks are counted
+type CountingLine struct {
+	Name string `json:"name"`
+	// Start and End are [x, y] points, in pixels or, if Normalized, as fractions of the image size
+	Start      []float64 `json:"start"`
+	End        []float64 `json:"end"`
+	Normalized bool      `json:"normalized,omitempty"`
+	// Direction is the only direction counted, both are counted if empty
+	Direction string `json:"direction,omitempty"`
+}
+
+func (l *CountingLine) validate() error {
+	if l.Name == "" {
+		return errors.New("counting lines must have a name")
+	}
+	if len(l.Start) != 2 || len(l.End) != 2 {
+		return errors.Errorf("start and end of counting line %v must be [x, y] points", l.Name)
+	}
+	if l.Start[0] == l.End[0] && l.Start[1] == l.End[1] {
+		return errors.Errorf("start and end of counting line %v must be different", l.Name)
+	}
+	if l.Normalized {
+		for _, c := range append(append([]float64{}, l.Start...), l.End...) {
+			if c < 0 || c > 1 {
+				return errors.Errorf("normalized coordinates of counting line %v must be between 0.0 and 1.0", l.Name)
+			}
+		}
+	}
+	if l.Direction != "" && l.Direction != DirectionIn && l.Direction != DirectionOut {
+		return errors.Errorf("direction of counting line %v must be %q, %q or empty", l.Name, DirectionIn, DirectionOut)
+	}
+	return nil
+}
+
+// directions returns the directions counted on the line
+func (l *CountingLine) directions() []string {
+	if l.Direction != "" {
+		return []string{l.Direction}
+	}
+	return []string{DirectionIn, DirectionOut}
+}
+
+type point struct {
+	x, y float64
+}
+
+// toPixels converts an [x, y] point of the config to pixels, scaling it to the image if it is normalized
+func toPixels(p []float64, normalized bool, bounds image.Rectangle) point {
+	if !normalized {
+		return point{p[0], p[1]}
+	}
+	return point{
+		float64(bounds.Min.X) + p[0]*float64(bounds.Dx()),
+		float64(bounds.Min.Y) + p[1]*float64(bounds.Dy()),
+	}
+}
+
+// endpoints returns the ends of the line in pixels
+func (l *CountingLine) endpoints(bounds image.Rectangle) (point, point) {
+	return toPixels(l.Start, l.Normalized, bounds), toPixels(l.End, l.Normalized, bounds)
+}
+
+func boxCenter(bb *image.Rectangle) point {
+	return point{float64(bb.Min.X+bb.Max.X) / 2, float64(bb.Min.Y+bb.Max.Y) / 2}
+}
+
+// side returns the cross product of (b - a) and (p - a), positive when p is on the right of the line from a to b
+func side(a, b, p point) float64 {
+	return (b.x-a.x)*(p.y-a.y) - (b.y-a.y)*(p.x-a.x)
+}
+
+// crossing returns the direction in which the movement from p to q crosses the line from a to b,
+// or an empty string if it does not cross it. A point lying exactly on the line counts as being on its left.
+func crossing(a, b, p, q point) string {
+	sp, sq := side(a, b, p), side(a, b, q)
+	if (sp > 0) == (sq > 0) {
+		return ""
+	}
+	// the movement must also pass between the ends of the line
+	sa, sb := side(p, q, a), side(p, q, b)
+	if (sa > 0 && sb > 0) || (sa < 0 && sb < 0) {
+		return ""
+	}
+	if sq > 0 {
+		return DirectionIn
+	}
+	return DirectionOut
+}
+
+// lineCount is the number of crossings of a counting line, in total and per class
+type lineCount struct {
+	In         int
+	Out        int
+	InByClass  map[string]int
+	OutByClass map[string]int
+}
+
+type lineCounter struct {
+	mutex  sync.RWMutex
+	lines  []CountingLine
+	counts map[string]*lineCount
+}
+
+func newLineCounter(lines []CountingLine) *lineCounter {
+	c := &lineCounter{
+		lines:  lines,
+		counts: make(map[string]*lineCount, len(lines)),
+	}
+	for _, l := range lines {
+		c.counts[l.Name] = &lineCount{InByClass: map[string]int{}, OutByClass: map[string]int{}}
+	}
+	return c
+}
+
+// add counts one crossing of the line by a track of the given class
+func (c *lineCounter) add(line, class, direction string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	count, ok := c.counts[line]
+	if !ok {
+		return
+	}
+	switch direction {
+	case DirectionIn:
+		count.In++
+		count.InByClass[class]++
+	case DirectionOut:
+		count.Out++
+		count.OutByClass[class]++
+	}
+}
+
+// snapshot returns a copy of the counts of every line
+func (c *lineCounter) snapshot() map[string]lineCount {
+	out := make(map[string]lineCount)
+	if c == nil {
+		return out
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for name, count := range c.counts {
+		cp := lineCount{
+			In:         count.In,
+			Out:        count.Out,
+			InByClass:  make(map[string]int, len(count.InByClass)),
+			OutByClass: make(map[string]int, len(count.OutByClass)),
+		}
+		for class, n := range count.InByClass {
+			cp.InByClass[class] = n
+		}
+		for class, n := range count.OutByClass {
+			cp.OutByClass[class] = n
+		}
+		out[name] = cp
+	}
+	return out
+}
+
+// classifications returns the counts as labels such as "line_oven_in:3", in the order of the lines
+func (c *lineCounter) classifications() []classification.Classification {
+	if c == nil {
+		return nil
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	out := make([]classification.Classification, 0, 2*len(c.lines))
+	for _, l := range c.lines {
+		count := c.counts[l.Name]
+		for _, dir := range l.directions() {
+			n := count.In
+			if dir == DirectionOut {
+				n = count.Out
+			}
+			out = append(out, classification.NewClassification(1, fmt.Sprintf("line_%s_%s:%d", l.Name, dir, n)))
+		}
+	}
+	return out
+}
+
+// countCrossings counts the stable tracks whose center crossed a counting line since their previous bounding box
+func (t *myTracker) countCrossings(tracks []*track, bounds image.Rectangle) {
+	if t.lineCounter == nil || len(t.lineCounter.lines) == 0 {
+		return
+	}
+	for _, tr := range tracks {
+		if !tr.isStable() {
+			continue
+		}
+		label := getTrackingLabel(tr)
+		history := t.tracks[label]
+		if len(history) < 2 || history[len(history)-1] != tr {
+			continue
+		}
+		prev := boxCenter(history[len(history)-2].Det.BoundingBox())
+		curr := boxCenter(tr.Det.BoundingBox())
+		class := tr.class
+		for i := range t.lineCounter.lines {
+			l := &t.lineCounter.lines[i]
+			start, end := l.endpoints(bounds)
+			dir := crossing(start, end, prev, curr)
+			if dir == "" || (l.Direction != "" && l.Direction != dir) {
+				continue
+			}
+			t.lineCounter.add(l.Name, class, dir)
+		}
+	}
+}
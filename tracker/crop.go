@@ -0,0 +1,212 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the preparation of the crops given to the classifier
+package tracker
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+	objdet "go.viam.com/rdk/vision/objectdetection"
+	xdraw "golang.org/x/image/draw"
+)
+
+// DefaultCropDumpEveryN is the default number of crops between two crops saved to the dump directory
+var DefaultCropDumpEveryN = 100
+
+// maxPendingDumps is the number of crops that can be waiting to be saved, the next ones are skipped
+const maxPendingDumps = 8
+
+// CropOptions describes how the crops given to the classifier are cut from the image
+type CropOptions struct {
+	// PaddingFraction pads each side of the box by this fraction of the width and height of the box
+	PaddingFraction float64 `json:"padding_fraction,omitempty"`
+	// PaddingPixels pads each side of the box by this number of pixels, after PaddingFraction
+	PaddingPixels int `json:"padding_px,omitempty"`
+	// Square letterboxes the crop to a square, keeping its aspect ratio
+	Square bool `json:"square,omitempty"`
+	// Size resizes the crop to Size x Size pixels
+	Size int `json:"size,omitempty"`
+	// Clamp restricts the crop to the image, true by default. Otherwise the parts outside the image are black.
+	Clamp *bool `json:"clamp,omitempty"`
+	// DumpDir is a directory where one crop out of DumpEveryN is saved as a PNG file, for labeling
+	DumpDir    string `json:"dump_dir,omitempty"`
+	DumpEveryN int    `json:"dump_every_n,omitempty"`
+}
+
+// Validate checks the crop options
+func (o *CropOptions) Validate() error {
+	if o.PaddingFraction < 0 {
+		return errors.New("classifier_crop padding_fraction cannot be less than 0")
+	}
+	if o.PaddingPixels < 0 {
+		return errors.New("classifier_crop padding_px cannot be less than 0")
+	}
+	if o.Size < 0 {
+		return errors.New("classifier_crop size cannot be less than 0")
+	}
+	if o.DumpEveryN < 0 {
+		return errors.New("classifier_crop dump_every_n cannot be less than 0")
+	}
+	return nil
+}
+
+// cropper cuts the crops given to the classifier. A nil cropper cuts the exact bounding box.
+type cropper struct {
+	options CropOptions
+	dumped  atomic.Int64
+	logger  logging.Logger
+	// the crops are saved in the background, not to slow down the classifier workers
+	pendingDumps atomic.Int64
+	dumping      sync.WaitGroup
+	// once closed, a cropper still in use by a classifier worker no longer saves crops
+	dumpMutex sync.Mutex
+	closed    bool
+}
+
+func newCropper(options *CropOptions, logger logging.Logger) (*cropper, error) {
+	if options == nil {
+		return nil, nil
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	c := &cropper{options: *options, logger: logger}
+	if c.options.DumpDir != "" {
+		if c.options.DumpEveryN == 0 {
+			c.options.DumpEveryN = DefaultCropDumpEveryN
+		}
+		if err := os.MkdirAll(c.options.DumpDir, 0o755); err != nil {
+			return nil, errors.Wrapf(err, "unable to create classifier_crop dump_dir %v", c.options.DumpDir)
+		}
+	}
+	return c, nil
+}
+
+// region returns the padded box of the detection, restricted to the image if Clamp
+func (c *cropper) region(bb image.Rectangle, bounds image.Rectangle) image.Rectangle {
+	padX := int(c.options.PaddingFraction*float64(bb.Dx())) + c.options.PaddingPixels
+	padY := int(c.options.PaddingFraction*float64(bb.Dy())) + c.options.PaddingPixels
+	region := image.Rect(bb.Min.X-padX, bb.Min.Y-padY, bb.Max.X+padX, bb.Max.Y+padY)
+	if c.options.Clamp == nil || *c.options.Clamp {
+		region = region.Intersect(bounds)
+	}
+	return region
+}
+
+// crop returns the classifier input for the detection
+func (c *cropper) crop(img image.Image, det objdet.Detection) image.Image {
+	if c == nil {
+		return cropImageFromDet(img, det)
+	}
+	bb := *det.BoundingBox()
+	if bb.Max.X == 0 || bb.Max.Y == 0 {
+		bb = img.Bounds()
+	}
+	region := c.region(bb, img.Bounds())
+	if region.Empty() {
+		return cropImageFromDet(img, det)
+	}
+
+	// the parts of the region outside the image are black
+	w, h := region.Dx(), region.Dy()
+	if c.options.Square {
+		w, h = max(w, h), max(w, h)
+	}
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(out, out.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	offset := image.Pt((w-region.Dx())/2, (h-region.Dy())/2)
+	inside := region.Intersect(img.Bounds())
+	draw.Draw(out, inside.Sub(region.Min).Add(offset), img, inside.Min, draw.Src)
+
+	if c.options.Size > 0 && (w != c.options.Size || h != c.options.Size) {
+		resized := image.NewRGBA(image.Rect(0, 0, c.options.Size, c.options.Size))
+		xdraw.BiLinear.Scale(resized, resized.Bounds(), out, out.Bounds(), draw.Src, nil)
+		out = resized
+	}
+	c.dump(out, det)
+	return out
+}
+
+// dump saves one crop out of DumpEveryN to the dump directory in the background, named after the class of
+// the detection. The crop is skipped if too many crops are still being saved.
+func (c *cropper) dump(crop image.Image, det objdet.Detection) {
+	if c.options.DumpDir == "" {
+		return
+	}
+	n := c.dumped.Add(1) - 1
+	if n%int64(c.options.DumpEveryN) != 0 {
+		return
+	}
+	c.dumpMutex.Lock()
+	defer c.dumpMutex.Unlock()
+	if c.closed {
+		return
+	}
+	if c.pendingDumps.Add(1) > maxPendingDumps {
+		c.pendingDumps.Add(-1)
+		c.logger.Warnf("skipping crop %d, too many crops are being saved", n)
+		return
+	}
+	name := fmt.Sprintf("%s_%s_%d.png", detectionClass(det), time.Now().Format(timestampFormat), n)
+	c.dumping.Add(1)
+	go func() {
+		defer c.dumping.Done()
+		defer c.pendingDumps.Add(-1)
+		c.save(crop, name)
+	}()
+}
+
+// wait returns once the crops being saved are written
+func (c *cropper) wait() {
+	if c == nil {
+		return
+	}
+	c.dumping.Wait()
+}
+
+// close stops saving new crops and returns once the crops being saved are written
+func (c *cropper) close() {
+	if c == nil {
+		return
+	}
+	c.dumpMutex.Lock()
+	c.closed = true
+	c.dumpMutex.Unlock()
+	c.dumping.Wait()
+}
+
+// useCropper replaces the cropper of the tracker with one built from options. The old cropper is closed,
+// so its crops are all written before it returns, and none are saved to its dump directory afterwards.
+func (t *myTracker) useCropper(options *CropOptions) error {
+	c, err := newCropper(options, t.logger)
+	if err != nil {
+		return err
+	}
+	old := t.cropper
+	t.cropper = c
+	old.close()
+	return nil
+}
+
+// save writes the crop to the dump directory as a PNG file
+func (c *cropper) save(crop image.Image, name string) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, crop); err != nil {
+		c.logger.Warnf("unable to encode crop. got err: %s", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(c.options.DumpDir, name), buf.Bytes(), 0o644); err != nil {
+		c.logger.Warnf("unable to save crop. got err: %s", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains methods that query one or several detectors and fuse their detections
+package tracker
+
+import (
+	"context"
+	"image"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/services/vision"
+	objdet "go.viam.com/rdk/vision/objectdetection"
+)
+
+// DefaultEnsembleIOUThreshold is the default overlap above which the detections of several detectors are fused
+var DefaultEnsembleIOUThreshold = 0.55
+
+// DetectorConfig is one of the detectors of an ensemble
+type DetectorConfig struct {
+	Name string `json:"name"`
+	// Weight of the detections of this detector in the fusion, 1 by default
+	Weight *float64 `json:"weight,omitempty"`
+	// LabelMap renames the labels returned by this detector before filtering, e.g. {"food": "pizza"}
+	LabelMap map[string]string `json:"label_map,omitempty"`
+}
+
+// ensembleMember is a detector of the ensemble with its fusion parameters
+type ensembleMember struct {
+	name     string
+	detector vision.Service
+	weight   float64
+	labelMap map[string]string
+}
+
+// remapLabels returns the detections with their labels renamed according to the label map of the detector
+func (m *ensembleMember) remapLabels(detections []objdet.Detection) []objdet.Detection {
+	if len(m.labelMap) == 0 {
+		return detections
+	}
+	out := make([]objdet.Detection, 0, len(detections))
+	for _, d := range detections {
+		if label, ok := m.labelMap[d.Label()]; ok {
+			d = objdet.NewDetection(*d.BoundingBox(), d.Score(), label)
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// detect queries all the detectors in parallel and fuses their detections with weighted box fusion.
+// A detector that fails is skipped, an error is only returned if all of them fail. The score of a fused
+// detection is the sum of the weighted scores divided by the total weight of the detectors that answered.
+func (t *myTracker) detect(ctx context.Context, img image.Image) ([]objdet.Detection, error) {
+	if len(t.detectors) == 1 {
+		m := t.detectors[0]
+		detections, err := m.detector.Detections(ctx, img, nil)
+		if err != nil {
+			return nil, err
+		}
+		return t.aliasLabels(m.remapLabels(detections)), nil
+	}
+
+	results := make([][]objdet.Detection, len(t.detectors))
+	errs := make([]error, len(t.detectors))
+	var wg sync.WaitGroup
+	for i := range t.detectors {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := t.detectors[i]
+			detections, err := m.detector.Detections(ctx, img, nil)
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "detector %v", m.name)
+				return
+			}
+			results[i] = t.aliasLabels(m.remapLabels(detections))
+		}(i)
+	}
+	wg.Wait()
+
+	var all []objdet.Detection
+	var weights []float64
+	totalWeight := 0.0
+	failed := 0
+	for i, m := range t.detectors {
+		if errs[i] != nil {
+			t.logger.Warnf("can't get detections. got err: %s", errs[i])
+			failed++
+			continue
+		}
+		totalWeight += m.weight
+		for _, d := range results[i] {
+			all = append(all, d)
+			weights = append(weights, m.weight)
+		}
+	}
+	if failed == len(t.detectors) {
+		return nil, errors.Errorf("all %d detectors failed, first error: %v", failed, errs[0])
+	}
+	return fuseBoxes(all, weights, t.ensembleIOUThreshold, false, totalWeight), nil
+}
@@ -3,6 +3,9 @@
 package tracker
 
 import (
+	"image"
+
+	"github.com/pkg/errors"
 	objdet "go.viam.com/rdk/vision/objectdetection"
 	"strings"
 )
@@ -19,7 +22,7 @@ func NewAdvancedFilter(chosenLabels map[string]float64) objdet.Postprocessor {
 		}
 		out := make([]objdet.Detection, 0, len(detections))
 		for _, d := range detections {
-			baseLabel := strings.ToLower(strings.Split(d.Label(), "_")[0])
+			baseLabel := detectionClass(d)
 			minConf, ok := chosenLabels[baseLabel]
 			if ok {
 				if d.Score() > minConf {
@@ -31,7 +34,241 @@ func NewAdvancedFilter(chosenLabels map[string]float64) objdet.Postprocessor {
 	}
 }
 
+// NewRegionFilter returns a Detections->Detections filtering method to remove detections
+// that are not in any of the include regions (if there are some), or that are in one of
+// the exclude regions. bounds is the size of the image, used for normalized regions.
+func NewRegionFilter(include, exclude []Region, bounds image.Rectangle) objdet.Postprocessor {
+	includePolys := make([][]point, len(include))
+	for i := range include {
+		includePolys[i] = include[i].polygon(bounds)
+	}
+	excludePolys := make([][]point, len(exclude))
+	for i := range exclude {
+		excludePolys[i] = exclude[i].polygon(bounds)
+	}
+	return func(detections []objdet.Detection) []objdet.Detection {
+		// If there are no regions, return the input.
+		if len(include) == 0 && len(exclude) == 0 {
+			return detections
+		}
+		out := make([]objdet.Detection, 0, len(detections))
+		for _, d := range detections {
+			bb := d.BoundingBox()
+			included := len(include) == 0
+			for i := range include {
+				if include[i].contains(includePolys[i], bb) {
+					included = true
+					break
+				}
+			}
+			for i := range exclude {
+				if included && exclude[i].contains(excludePolys[i], bb) {
+					included = false
+				}
+			}
+			if included {
+				out = append(out, d)
+			}
+		}
+		return out
+	}
+}
+
 func FilterDetections(chosenLabels map[string]float64, dets []objdet.Detection, conf float64) []objdet.Detection {
 	firstPass := NewAdvancedFilter(chosenLabels)(dets)
 	return objdet.NewScoreFilter(conf)(firstPass)
 }
+
+// FilterLowConfidenceDetections returns the detections that FilterDetections would discard because of
+// their score, but that still reach lowConf. Their class name still has to be in chosenLabels (if not empty).
+// The score threshold of a chosen label counts like conf: a detection above conf but not above the
+// threshold of its label is a low confidence detection.
+// They are used for the second stage of the association, to keep existing tracks alive.
+func FilterLowConfidenceDetections(chosenLabels map[string]float64, dets []objdet.Detection, conf, lowConf float64) []objdet.Detection {
+	out := make([]objdet.Detection, 0, len(dets))
+	for _, d := range dets {
+		if d.Score() < lowConf {
+			continue
+		}
+		// keep the detection only if it would not pass FilterDetections
+		discarded := d.Score() < conf
+		if len(chosenLabels) > 0 {
+			baseLabel := detectionClass(d)
+			labelConf, ok := chosenLabels[baseLabel]
+			if !ok {
+				continue
+			}
+			discarded = discarded || d.Score() <= labelConf
+		}
+		if discarded {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// GeometryConstraints are the optional constraints on the bounding boxes of a class.
+// Areas are fractions of the image area and aspect ratios are width / height, 0 meaning no constraint.
+type GeometryConstraints struct {
+	MinArea            float64 `json:"min_area,omitempty"`
+	MaxArea            float64 `json:"max_area,omitempty"`
+	MinAspect          float64 `json:"min_aspect,omitempty"`
+	MaxAspect          float64 `json:"max_aspect,omitempty"`
+	DropBorderTouching bool    `json:"drop_border_touching,omitempty"`
+}
+
+// AllClasses is the key of the geometry constraints applied to the classes without constraints of their own
+const AllClasses = "*"
+
+// Validate checks that the bounds of the constraints are consistent
+func (c *GeometryConstraints) Validate() error {
+	if c.MinArea < 0 || c.MaxArea < 0 || c.MinArea > 1 || c.MaxArea > 1 {
+		return errors.New("min_area and max_area are fractions of the image area and must be between 0.0 and 1.0")
+	}
+	if c.MaxArea > 0 && c.MinArea > c.MaxArea {
+		return errors.New("min_area cannot be above max_area")
+	}
+	if c.MinAspect < 0 || c.MaxAspect < 0 {
+		return errors.New("min_aspect and max_aspect cannot be less than 0")
+	}
+	if c.MaxAspect > 0 && c.MinAspect > c.MaxAspect {
+		return errors.New("min_aspect cannot be above max_aspect")
+	}
+	return nil
+}
+
+// keepIf returns a Detections->Detections filtering method keeping the detections for which keep returns true
+func keepIf(keep func(objdet.Detection) bool) objdet.Postprocessor {
+	return func(detections []objdet.Detection) []objdet.Detection {
+		out := make([]objdet.Detection, 0, len(detections))
+		for _, d := range detections {
+			if keep(d) {
+				out = append(out, d)
+			}
+		}
+		return out
+	}
+}
+
+// NewAreaFilter returns a Detections->Detections filtering method to remove detections whose
+// bounding box covers less than minArea or more than maxArea of the image (0 for no limit).
+func NewAreaFilter(minArea, maxArea float64, bounds image.Rectangle) objdet.Postprocessor {
+	imgArea := area(bounds)
+	return keepIf(func(d objdet.Detection) bool {
+		if imgArea == 0 {
+			return true
+		}
+		fraction := area(*d.BoundingBox()) / imgArea
+		return fraction >= minArea && (maxArea == 0 || fraction <= maxArea)
+	})
+}
+
+// NewAspectRatioFilter returns a Detections->Detections filtering method to remove detections whose
+// bounding box has a width / height ratio below minAspect or above maxAspect (0 for no limit).
+func NewAspectRatioFilter(minAspect, maxAspect float64) objdet.Postprocessor {
+	return keepIf(func(d objdet.Detection) bool {
+		bb := d.BoundingBox()
+		if bb.Dy() == 0 {
+			return maxAspect == 0
+		}
+		aspect := float64(bb.Dx()) / float64(bb.Dy())
+		return aspect >= minAspect && (maxAspect == 0 || aspect <= maxAspect)
+	})
+}
+
+// NewBorderFilter returns a Detections->Detections filtering method to remove detections whose
+// bounding box touches the border of the image.
+func NewBorderFilter(bounds image.Rectangle) objdet.Postprocessor {
+	return keepIf(func(d objdet.Detection) bool {
+		bb := d.BoundingBox()
+		return bb.Min.X > bounds.Min.X && bb.Min.Y > bounds.Min.Y && bb.Max.X < bounds.Max.X && bb.Max.Y < bounds.Max.Y
+	})
+}
+
+// ComposeFilters returns a Detections->Detections filtering method applying the filters in order
+func ComposeFilters(filters ...objdet.Postprocessor) objdet.Postprocessor {
+	return func(detections []objdet.Detection) []objdet.Detection {
+		for _, f := range filters {
+			detections = f(detections)
+		}
+		return detections
+	}
+}
+
+// applyToClasses returns a Detections->Detections filtering method applying the filter to the detections
+// whose class name matches, and keeping all the other ones. The order of the detections is preserved.
+func applyToClasses(match func(class string) bool, filter objdet.Postprocessor) objdet.Postprocessor {
+	return func(detections []objdet.Detection) []objdet.Detection {
+		var matching []objdet.Detection
+		for _, d := range detections {
+			if match(detectionClass(d)) {
+				matching = append(matching, d)
+			}
+		}
+		if len(matching) == 0 {
+			return detections
+		}
+		kept := make(map[objdet.Detection]struct{}, len(matching))
+		for _, d := range filter(matching) {
+			kept[d] = struct{}{}
+		}
+		out := make([]objdet.Detection, 0, len(detections))
+		for _, d := range detections {
+			_, ok := kept[d]
+			if ok || !match(detectionClass(d)) {
+				out = append(out, d)
+			}
+		}
+		return out
+	}
+}
+
+// NewClassFilter returns a Detections->Detections filtering method applying the filter only to the
+// detections of the given class, the detections of the other classes are all kept.
+func NewClassFilter(class string, filter objdet.Postprocessor) objdet.Postprocessor {
+	class = strings.ToLower(class)
+	return applyToClasses(func(c string) bool { return c == class }, filter)
+}
+
+// Postprocessor returns the composition of the area, aspect ratio and border filters set in the constraints
+func (c *GeometryConstraints) Postprocessor(bounds image.Rectangle) objdet.Postprocessor {
+	var filters []objdet.Postprocessor
+	if c.MinArea > 0 || c.MaxArea > 0 {
+		filters = append(filters, NewAreaFilter(c.MinArea, c.MaxArea, bounds))
+	}
+	if c.MinAspect > 0 || c.MaxAspect > 0 {
+		filters = append(filters, NewAspectRatioFilter(c.MinAspect, c.MaxAspect))
+	}
+	if c.DropBorderTouching {
+		filters = append(filters, NewBorderFilter(bounds))
+	}
+	return ComposeFilters(filters...)
+}
+
+// NewGeometryFilter returns a Detections->Detections filtering method applying to each class its own
+// constraints, or the constraints of AllClasses if it has none.
+func NewGeometryFilter(constraints map[string]GeometryConstraints, bounds image.Rectangle) objdet.Postprocessor {
+	filters := make([]objdet.Postprocessor, 0, len(constraints))
+	for class, c := range constraints {
+		if class == AllClasses {
+			continue
+		}
+		filters = append(filters, NewClassFilter(class, c.Postprocessor(bounds)))
+	}
+	if c, ok := constraints[AllClasses]; ok {
+		filters = append(filters, applyToClasses(func(class string) bool {
+			_, ok := constraints[class]
+			return !ok
+		}, c.Postprocessor(bounds)))
+	}
+	return ComposeFilters(filters...)
+}
+
+// filterGeometry removes the detections that are outside of the regions of interest of the tracker,
+// or whose bounding box does not meet the geometry constraints of its class
+func (t *myTracker) filterGeometry(dets []objdet.Detection, bounds image.Rectangle) []objdet.Detection {
+	return ComposeFilters(
+		NewRegionFilter(t.includeRegions, t.excludeRegions, bounds),
+		NewGeometryFilter(t.geometryConstraints, bounds),
+	)(dets)
+}
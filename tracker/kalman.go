@@ -0,0 +1,183 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the constant-velocity Kalman filter used to predict where a track will be.
+package tracker
+
+import (
+	"image"
+	"math"
+	"time"
+)
+
+// Indices of the quantities tracked by the filter. The box is described by its center,
+// its scale (square root of the area) and its aspect ratio (width / height).
+const (
+	kfCenterX = iota
+	kfCenterY
+	kfScale
+	kfAspect
+	kfNumDims
+)
+
+const (
+	// relative standard deviation of the detector's center and scale measurements
+	measurementNoiseRel = 0.05
+	// standard deviation of the detector's aspect ratio measurements
+	aspectMeasurementNoise = 0.05
+	// relative standard deviation of the center and scale acceleration, per second squared
+	accelerationNoiseRel = 1.0
+	// standard deviation of the aspect ratio acceleration, per second squared
+	aspectAccelerationNoise = 0.1
+	// relative standard deviation of the initial center and scale velocities, per second
+	initialVelocityNoiseRel = 2.0
+	// standard deviation of the initial aspect ratio velocity, per second
+	initialAspectVelocityNoise = 0.1
+	// predictions are never extrapolated further than this in the future
+	maxPredictionHorizon = time.Second
+)
+
+// kalmanDim is a 1D constant-velocity filter over one quantity of the box.
+// Because the center, scale and aspect ratio move independently, the full state
+// (center, scale, aspect ratio and their velocities) is the product of four of them.
+type kalmanDim struct {
+	x, v float64
+	p    [2][2]float64
+}
+
+// predict moves the state dt seconds forward, q being the acceleration noise variance.
+func (k *kalmanDim) predict(dt, q float64) {
+	k.x += k.v * dt
+	p := k.p
+	k.p[0][0] = p[0][0] + dt*(p[0][1]+p[1][0]) + dt*dt*p[1][1] + q*dt*dt*dt/3
+	k.p[0][1] = p[0][1] + dt*p[1][1] + q*dt*dt/2
+	k.p[1][0] = p[1][0] + dt*p[1][1] + q*dt*dt/2
+	k.p[1][1] = p[1][1] + q*dt
+}
+
+// update corrects the state with the measurement z, r being the measurement noise variance.
+func (k *kalmanDim) update(z, r float64) {
+	s := k.p[0][0] + r
+	k0, k1 := k.p[0][0]/s, k.p[1][0]/s
+	y := z - k.x
+	k.x += k0 * y
+	k.v += k1 * y
+	p := k.p
+	k.p[0][0] = (1 - k0) * p[0][0]
+	k.p[0][1] = (1 - k0) * p[0][1]
+	k.p[1][0] = p[1][0] - k1*p[0][0]
+	k.p[1][1] = p[1][1] - k1*p[0][1]
+}
+
+// kalmanFilter estimates the position and velocity of a bounding box over time.
+// Velocities are expressed per second so that predictions scale with the real time between frames.
+type kalmanFilter struct {
+	dims       [kfNumDims]kalmanDim
+	lastUpdate time.Time
+}
+
+// newKalmanFilter starts a filter at the given bounding box, with unknown velocity.
+func newKalmanFilter(bb image.Rectangle, ts time.Time) *kalmanFilter {
+	z := boxToMeasurement(bb)
+	kf := &kalmanFilter{lastUpdate: ts}
+	for i := range kf.dims {
+		measStd, _ := kf.noise(i, z[kfScale])
+		velStd := initialVelocityNoiseRel * math.Max(z[kfScale], 1)
+		if i == kfAspect {
+			velStd = initialAspectVelocityNoise
+		}
+		kf.dims[i].x = z[i]
+		kf.dims[i].p[0][0] = measStd * measStd
+		kf.dims[i].p[1][1] = velStd * velStd
+	}
+	return kf
+}
+
+// noise returns the measurement and acceleration standard deviations for a dimension,
+// given the current scale of the box.
+func (kf *kalmanFilter) noise(dim int, scale float64) (float64, float64) {
+	if dim == kfAspect {
+		return aspectMeasurementNoise, aspectAccelerationNoise
+	}
+	scale = math.Max(scale, 1)
+	return measurementNoiseRel * scale, accelerationNoiseRel * scale
+}
+
+// clone returns an independent copy of the filter
+func (kf *kalmanFilter) clone() *kalmanFilter {
+	if kf == nil {
+		return nil
+	}
+	out := *kf
+	return &out
+}
+
+// elapsed returns the time in seconds between the last update and ts, capped to maxPredictionHorizon.
+func (kf *kalmanFilter) elapsed(ts time.Time) float64 {
+	dt := ts.Sub(kf.lastUpdate)
+	if dt < 0 {
+		return 0
+	}
+	if dt > maxPredictionHorizon {
+		dt = maxPredictionHorizon
+	}
+	return dt.Seconds()
+}
+
+// step moves the whole state forward to ts
+func (kf *kalmanFilter) step(ts time.Time) {
+	dt := kf.elapsed(ts)
+	scale := kf.dims[kfScale].x
+	for i := range kf.dims {
+		_, accStd := kf.noise(i, scale)
+		kf.dims[i].predict(dt, accStd*accStd)
+	}
+}
+
+// predict returns the expected bounding box at time ts without modifying the filter.
+func (kf *kalmanFilter) predict(ts time.Time) image.Rectangle {
+	next := kf.clone()
+	next.step(ts)
+	return next.box()
+}
+
+// update moves the filter forward to ts and corrects it with the measured bounding box.
+func (kf *kalmanFilter) update(bb image.Rectangle, ts time.Time) {
+	kf.step(ts)
+	z := boxToMeasurement(bb)
+	for i := range kf.dims {
+		measStd, _ := kf.noise(i, z[kfScale])
+		kf.dims[i].update(z[i], measStd*measStd)
+	}
+	if ts.After(kf.lastUpdate) {
+		kf.lastUpdate = ts
+	}
+}
+
+// box converts the current state of the filter into a bounding box
+func (kf *kalmanFilter) box() image.Rectangle {
+	cx, cy := kf.dims[kfCenterX].x, kf.dims[kfCenterY].x
+	scale, aspect := kf.dims[kfScale].x, kf.dims[kfAspect].x
+	if scale <= 0 || aspect <= 0 {
+		return image.Rect(int(math.Round(cx)), int(math.Round(cy)), int(math.Round(cx)), int(math.Round(cy)))
+	}
+	w := scale * math.Sqrt(aspect)
+	h := scale / math.Sqrt(aspect)
+	return image.Rect(
+		int(math.Round(cx-w/2)), int(math.Round(cy-h/2)),
+		int(math.Round(cx+w/2)), int(math.Round(cy+h/2)),
+	)
+}
+
+// boxToMeasurement returns the center, scale and aspect ratio of a bounding box
+func boxToMeasurement(bb image.Rectangle) [kfNumDims]float64 {
+	w, h := float64(bb.Dx()), float64(bb.Dy())
+	var z [kfNumDims]float64
+	z[kfCenterX] = float64(bb.Min.X) + w/2
+	z[kfCenterY] = float64(bb.Min.Y) + h/2
+	z[kfScale] = math.Sqrt(w * h)
+	if h > 0 {
+		z[kfAspect] = w / h
+	} else {
+		z[kfAspect] = 1
+	}
+	return z
+}
@@ -0,0 +1,136 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the templates used to build the labels of the tracked detections
+package tracker
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Label modes, as given in the config
+const (
+	// LabelModeTrack labels the output detections with the label_format template
+	LabelModeTrack = "track"
+	// LabelModeClass labels the output detections with their class, the track labels are returned in the extra of CaptureAllFromCamera
+	LabelModeClass = "class"
+)
+
+// DefaultLabelFormat is the template of the labels, class_N_YYYYMMDD_HHMMSS followed by _classification if the track has one
+var DefaultLabelFormat = "{class}_{id}_{first_seen:" + timestampFormat + "}_{classification}"
+
+// Fields of the label templates
+const (
+	labelFieldClass          = "class"
+	labelFieldID             = "id"
+	labelFieldFirstSeen      = "first_seen"
+	labelFieldClassification = "classification"
+	labelFieldUUID           = "uuid"
+)
+
+// labelPart is a field of a template and the text preceding it. The last part of a template may have no field.
+type labelPart struct {
+	literal string
+	field   string
+	// layout of the time fields
+	layout string
+}
+
+// labelFormat is a parsed label template
+type labelFormat struct {
+	template string
+	parts    []labelPart
+}
+
+var defaultLabelFormat = mustParseLabelFormat(DefaultLabelFormat)
+
+func mustParseLabelFormat(template string) *labelFormat {
+	f, err := parseLabelFormat(template)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// parseLabelFormat parses a template made of text and {field} placeholders, where field is one of class, id,
+// first_seen (optionally followed by a Go time layout, as in {first_seen:20060102}), classification or uuid.
+// The template must contain {id} or {uuid} so that two tracks never get the same label.
+func parseLabelFormat(template string) (*labelFormat, error) {
+	f := &labelFormat{template: template}
+	unique := false
+	rest := template
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			if strings.IndexByte(rest, '}') >= 0 {
+				return nil, errors.Errorf("label_format %q has an unmatched '}'", template)
+			}
+			f.parts = append(f.parts, labelPart{literal: rest})
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, errors.Errorf("label_format %q has an unmatched '{'", template)
+		}
+		literal, placeholder := rest[:start], rest[start+1:start+end]
+		if strings.Contains(literal, "}") || strings.Contains(placeholder, "{") {
+			return nil, errors.Errorf("label_format %q has unbalanced braces", template)
+		}
+		part := labelPart{literal: literal, field: placeholder}
+		if i := strings.IndexByte(placeholder, ':'); i >= 0 {
+			part.field, part.layout = placeholder[:i], placeholder[i+1:]
+		}
+		switch part.field {
+		case labelFieldFirstSeen:
+			if part.layout == "" {
+				part.layout = timestampFormat
+			}
+		case labelFieldClass, labelFieldID, labelFieldClassification, labelFieldUUID:
+			if part.layout != "" {
+				return nil, errors.Errorf("label_format field %v does not take a layout", part.field)
+			}
+		default:
+			return nil, errors.Errorf("unknown label_format field %q, must be one of %v, %v, %v, %v or %v", part.field,
+				labelFieldClass, labelFieldID, labelFieldFirstSeen, labelFieldClassification, labelFieldUUID)
+		}
+		unique = unique || part.field == labelFieldID || part.field == labelFieldUUID
+		f.parts = append(f.parts, part)
+		rest = rest[start+end+1:]
+	}
+	if !unique {
+		return nil, errors.Errorf("label_format %q must contain {%v} or {%v}", template, labelFieldID, labelFieldUUID)
+	}
+	return f, nil
+}
+
+// label returns the label of a named track. A field that is empty, like the classification of a track
+// that was not classified, is left out along with the text preceding it. A nil format is the default format.
+func (f *labelFormat) label(tr *track) string {
+	if f == nil {
+		f = defaultLabelFormat
+	}
+	var b strings.Builder
+	for _, part := range f.parts {
+		value := ""
+		switch part.field {
+		case "":
+		case labelFieldClass:
+			value = tr.class
+		case labelFieldID:
+			value = strconv.Itoa(tr.id)
+		case labelFieldFirstSeen:
+			value = tr.namedAt.Format(part.layout)
+		case labelFieldClassification:
+			value = tr.classification
+		case labelFieldUUID:
+			value = tr.uuid
+		}
+		if part.field != "" && value == "" {
+			continue
+		}
+		b.WriteString(part.literal)
+		b.WriteString(value)
+	}
+	return b.String()
+}
@@ -1,7 +1,7 @@
 // Package tracker implements an object tracker as a Viam vision service.
 // This file contains methods that handle the label (or name) of a detection
 // If two detections are output with the same label, they are considered the same object
-// Labels are of the format classname_N_YYYYMMDD_HHMM
+// Labels are of the format classname_N_YYYYMMDD_HHMMSS by default, see label_format
 package tracker
 
 import (
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	objdet "go.viam.com/rdk/vision/objectdetection"
 )
 
@@ -18,9 +19,22 @@ const (
 	FullPizzaLabel    = "full"
 )
 
+// timestampFormat is the format of the time at which a track was named, YYYYMMDD_HHMMSS
+const timestampFormat = "20060102_150405"
+
 // GetTimestamp will retrieve and format a timestamp to be YYYYMMDD_HHMMSS
 func GetTimestamp() string {
-	return time.Now().Format("20060102_150405")
+	return time.Now().Format(timestampFormat)
+}
+
+// detectionClass returns the class of a detection given by a detector: its lowercase label up to the first underscore
+func detectionClass(det objdet.Detection) string {
+	return strings.ToLower(strings.Split(det.Label(), "_")[0])
+}
+
+// formatTrackingLabel returns the label identifying a track, in the form class_N
+func formatTrackingLabel(class string, id int) string {
+	return class + "_" + strconv.Itoa(id)
 }
 
 // ReplaceLabel replaces the detection with an almost identical detection (new label)
@@ -65,29 +79,41 @@ func (t *myTracker) RenameFromMatches(matches []int, matchinMtx [][]float64, old
 // RenameFirstTime should activate whenever a new object appears.
 // It will start or update a class counter for whichever class and create a new track.
 func (t *myTracker) RenameFirstTime(det *track) *track {
-	baseLabel := strings.ToLower(strings.Split(det.Det.Label(), "_")[0])
+	baseLabel := detectionClass(det.Det)
 	classCount, ok := t.classCounter[baseLabel]
 	if !ok {
 		t.classCounter[baseLabel] = 0
 	} else {
 		t.classCounter[baseLabel] = classCount + 1
 	}
-	var label string
-	countLabel := baseLabel + "_" + strconv.Itoa(t.classCounter[baseLabel])
+	// after the counters were reset, the numbers of the tracks still in memory are skipped
+	for _, inUse := t.tracks[formatTrackingLabel(baseLabel, t.classCounter[baseLabel])]; inUse; {
+		t.classCounter[baseLabel]++
+		_, inUse = t.tracks[formatTrackingLabel(baseLabel, t.classCounter[baseLabel])]
+	}
+	t.countersDirty, t.countersIssued = true, true
+	out := det.clone()
+	out.class = baseLabel
+	out.id = t.classCounter[baseLabel]
+	out.namedAt = time.Now()
 	if det.detClassification != nil {
-		label = countLabel + "_" + GetTimestamp() + "_" + det.detClassification.Label()
-	} else {
-		label = countLabel + "_" + GetTimestamp()
+		out.classification = t.classificationVoter.vote(out, det.detClassification)
+		out.classifiedBox = *out.Det.BoundingBox()
 	}
-	out := ReplaceLabel(det, label)
+	out.uuid = uuid.NewString()
+	out = ReplaceLabel(out, t.labelFormat.label(out))
+	countLabel := getTrackingLabel(out)
+	out.firstSeen = out.seenAt
+	out.kf = newKalmanFilter(*out.Det.BoundingBox(), out.seenAt)
 	// start a new track, but it will be tentative, and may be removed if lost
 	// before persistence counter reaches "stable"
 	t.tracks[countLabel] = []*track{out}
 	return out
 }
 
+// getTrackingLabel returns the label identifying a named track, in the form class_N
 func getTrackingLabel(tr *track) string {
-	return strings.Join(strings.Split(tr.Det.Label(), "_")[0:2], "_")
+	return formatTrackingLabel(tr.class, tr.id)
 }
 
 // UpdateTrack changes the old bounding box to the new one, updates persistence,
@@ -95,15 +121,45 @@ func getTrackingLabel(tr *track) string {
 func (t *myTracker) UpdateTrack(nextTrack, oldMatchedTrack *track) (*track, bool) {
 	wasStable := oldMatchedTrack.isStable()
 	newTrack := ReplaceBoundingBox(oldMatchedTrack, nextTrack.Det.BoundingBox())
-	newTrack.addPersistence()
+	newTrack.seenAt = nextTrack.seenAt
+	newTrack.stateAge++
+	newTrack.framesSinceClassified++
+	if newTrack.state == trackLost {
+		t.transition(newTrack, trackConfirmed)
+	}
+	if nextTrack.appearance != nil {
+		newTrack.appearance = updateAppearance(newTrack.appearance, nextTrack.appearance)
+	}
+	if nextTrack.embedding != nil {
+		newTrack.embedding = updateEmbedding(newTrack.embedding, nextTrack.embedding)
+	}
+	if newTrack.kf == nil {
+		newTrack.kf = newKalmanFilter(*newTrack.Det.BoundingBox(), newTrack.seenAt)
+	} else {
+		newTrack.kf.update(*newTrack.Det.BoundingBox(), newTrack.seenAt)
+	}
+	if newTrack.addPersistence() {
+		t.transition(newTrack, trackConfirmed)
+	}
 	if nextTrack.detClassification != nil {
-		newTrack = newTrack.addClassificationToLabel(nextTrack.detClassification.Label())
+		if newTrack.detClassification == nil {
+			newTrack.detClassification = nextTrack.detClassification
+		}
+		newTrack.framesSinceClassified = 0
+		newTrack.classifiedBox = *newTrack.Det.BoundingBox()
+		// the classification of the frame is a vote, the policy decides which classification the track gets
+		classification := t.classificationVoter.vote(newTrack, nextTrack.detClassification)
+		classification = t.applyTransitions(newTrack, classification)
+		newTrack = newTrack.addClassificationToLabel(classification, t.labelFormat)
+		if newTrack.isStable() {
+			t.logClassification(newTrack)
+		}
 	}
 
 	countLabel := getTrackingLabel(newTrack)
 	trackSlice, ok := t.tracks[countLabel]
 	if ok {
-		t.tracks[countLabel] = append(trackSlice, newTrack)
+		t.tracks[countLabel] = appendHistory(trackSlice, newTrack, t.historySize)
 	}
 	isNowStable := newTrack.isStable()
 	newlyStable := wasStable != isNowStable
@@ -111,7 +167,7 @@ func (t *myTracker) UpdateTrack(nextTrack, oldMatchedTrack *track) (*track, bool
 }
 
 // updateMatchedTracks sifts through the matching matrix and sends the correct tracks to be updated
-// Explicity prevents a match between a track with a "partial" classification and another with a  "full" classification
+// Explicity prevents a match between tracks whose classifications split the identity, e.g. "partial" and then "full"
 // Returns which tracks were simply updated, which JUST became stable, and which were unused.
 func (t *myTracker) updateMatchedTracks(matches []int, matchinMtx [][]float64, oldDets, newDets []*track,
 	notUsed map[int]struct{}) ([]*track, []*track, map[int]struct{}) {
@@ -124,13 +180,12 @@ func (t *myTracker) updateMatchedTracks(matches []int, matchinMtx [][]float64, o
 			if matchinMtx[oldIdx][newIdx] != 0 {
 				if newIdx >= 0 && newIdx < len(newDets) && oldIdx >= 0 && oldIdx < len(oldDets) {
 
-					// If the old one says partial and the new one says full, this is a NEW track
-					if oldDets[oldIdx].detClassification != nil && newDets[newIdx].detClassification != nil {
-						if oldDets[oldIdx].isStable() && oldDets[oldIdx].detClassification.Label() == PartialPizzaLabel &&
-							newDets[newIdx].detClassification.Label() == FullPizzaLabel {
-							// Skipping this one will mean newIdx stays in notUsed, so it will be added as a freshTrack
-							continue
-						}
+					// If the classification transition breaks the identity (e.g. partial to full), this is a NEW track
+					if t.splitsTrack(oldDets[oldIdx], newDets[newIdx]) {
+						// Skipping this one will mean newIdx stays in notUsed, so it will be added as a freshTrack,
+						// and the old track is considered unmatched
+						matches[oldIdx] = -1
+						continue
 					}
 
 					// take the old track, clone it, and update their Bounding Box
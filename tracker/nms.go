@@ -0,0 +1,179 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains methods that remove or merge the duplicate detections of an object
+package tracker
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	objdet "go.viam.com/rdk/vision/objectdetection"
+)
+
+// Methods used to deal with overlapping detections, as given in the config
+const (
+	NMSMethodSuppress = "nms"
+	NMSMethodWBF      = "wbf"
+)
+
+// boxIOU returns the intersection over union of 2 rectangles
+func boxIOU(r1, r2 *image.Rectangle) float64 {
+	inter, union, _ := overlap(r1, r2)
+	if union <= 0 {
+		return 0
+	}
+	return inter / union
+}
+
+// sameGroup returns whether 2 detections can be duplicates of each other
+func sameGroup(d1, d2 objdet.Detection, classAgnostic bool) bool {
+	if classAgnostic {
+		return true
+	}
+	return detectionClass(d1) == detectionClass(d2)
+}
+
+// sortByScore returns a copy of the detections sorted by decreasing score
+func sortByScore(detections []objdet.Detection) []objdet.Detection {
+	sorted := append([]objdet.Detection{}, detections...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Score() > sorted[j].Score()
+	})
+	return sorted
+}
+
+// NewNMSFilter returns a Detections->Detections filtering method to remove the detections that overlap
+// with a detection of higher score by more than iouThreshold. Unless classAgnostic, only detections
+// of the same class suppress each other.
+func NewNMSFilter(iouThreshold float64, classAgnostic bool) objdet.Postprocessor {
+	return func(detections []objdet.Detection) []objdet.Detection {
+		out := make([]objdet.Detection, 0, len(detections))
+		for _, d := range sortByScore(detections) {
+			suppressed := false
+			for _, kept := range out {
+				if sameGroup(d, kept, classAgnostic) && boxIOU(d.BoundingBox(), kept.BoundingBox()) > iouThreshold {
+					suppressed = true
+					break
+				}
+			}
+			if !suppressed {
+				out = append(out, d)
+			}
+		}
+		return out
+	}
+}
+
+// boxCluster is a group of detections of the same object, fused into one box
+type boxCluster struct {
+	members []objdet.Detection
+	weights []float64
+	fused   image.Rectangle
+}
+
+// fuse sets the fused box to the average of the boxes of the members, weighted by their score and weight
+func (c *boxCluster) fuse() {
+	var x0, y0, x1, y1, total float64
+	for i, d := range c.members {
+		bb, w := d.BoundingBox(), c.weights[i]*d.Score()
+		x0 += w * float64(bb.Min.X)
+		y0 += w * float64(bb.Min.Y)
+		x1 += w * float64(bb.Max.X)
+		y1 += w * float64(bb.Max.Y)
+		total += w
+	}
+	if total == 0 {
+		c.fused = *c.members[0].BoundingBox()
+		return
+	}
+	c.fused = image.Rect(
+		int(math.Round(x0/total)), int(math.Round(y0/total)),
+		int(math.Round(x1/total)), int(math.Round(y1/total)),
+	)
+}
+
+// fuseBoxes merges the detections that overlap by more than iouThreshold with weighted box fusion.
+// weights[i] is the weight of detections[i], all weights are 1 if nil. The score of a merged detection
+// is the weighted sum of the scores divided by totalWeight, or by the sum of the weights of its members
+// if totalWeight is 0. Its label is the label of the member with the highest weighted score.
+func fuseBoxes(detections []objdet.Detection, weights []float64, iouThreshold float64, classAgnostic bool, totalWeight float64) []objdet.Detection {
+	order := make([]int, len(detections))
+	for i := range order {
+		order[i] = i
+	}
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return weight(order[i])*detections[order[i]].Score() > weight(order[j])*detections[order[j]].Score()
+	})
+
+	var clusters []*boxCluster
+	for _, i := range order {
+		d := detections[i]
+		var match *boxCluster
+		for _, c := range clusters {
+			if sameGroup(d, c.members[0], classAgnostic) && boxIOU(d.BoundingBox(), &c.fused) > iouThreshold {
+				match = c
+				break
+			}
+		}
+		if match == nil {
+			clusters = append(clusters, &boxCluster{members: []objdet.Detection{d}, weights: []float64{weight(i)}, fused: *d.BoundingBox()})
+			continue
+		}
+		match.members = append(match.members, d)
+		match.weights = append(match.weights, weight(i))
+		match.fuse()
+	}
+
+	out := make([]objdet.Detection, 0, len(clusters))
+	for _, c := range clusters {
+		if len(c.members) == 1 && totalWeight == 0 {
+			out = append(out, c.members[0])
+			continue
+		}
+		score, norm := 0.0, totalWeight
+		for i, d := range c.members {
+			score += c.weights[i] * d.Score()
+			if totalWeight == 0 {
+				norm += c.weights[i]
+			}
+		}
+		if norm > 0 {
+			score /= norm
+		}
+		out = append(out, withRawLabel(objdet.NewDetection(c.fused, math.Min(score, 1), c.members[0].Label()), c.members[0]))
+	}
+	return out
+}
+
+// NewWeightedBoxFusion returns a Detections->Detections filtering method to merge the detections that overlap
+// by more than iouThreshold into one detection. The merged box is the average of the boxes weighted by their
+// score, its score is the average score and its label the label of the highest scoring detection.
+// Unless classAgnostic, only detections of the same class are merged.
+func NewWeightedBoxFusion(iouThreshold float64, classAgnostic bool) objdet.Postprocessor {
+	return func(detections []objdet.Detection) []objdet.Detection {
+		return fuseBoxes(detections, nil, iouThreshold, classAgnostic, 0)
+	}
+}
+
+// NewDuplicateFilter returns the postprocessor removing (NMSMethodSuppress) or merging (NMSMethodWBF)
+// the overlapping detections. An empty method is the same as NMSMethodSuppress.
+func NewDuplicateFilter(method string, iouThreshold float64, classAgnostic bool) (objdet.Postprocessor, error) {
+	if iouThreshold < 0 || iouThreshold > 1 {
+		return nil, errors.New("nms_iou_threshold must be between 0.0 and 1.0")
+	}
+	switch method {
+	case "", NMSMethodSuppress:
+		return NewNMSFilter(iouThreshold, classAgnostic), nil
+	case NMSMethodWBF:
+		return NewWeightedBoxFusion(iouThreshold, classAgnostic), nil
+	default:
+		return nil, errors.Errorf("unknown nms_method %q, must be %q or %q", method, NMSMethodSuppress, NMSMethodWBF)
+	}
+}
@@ -0,0 +1,144 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the regions of the image used to include or exclude detections
+package tracker
+
+import (
+	"image"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRegionOverlap is the default fraction of a bounding box that must be inside a region
+var DefaultRegionOverlap = 0.5
+
+// Region is an area of the image, given either as a rectangle or as a polygon
+type Region struct {
+	Name string `json:"name,omitempty"`
+	// Rectangle is [x_min, y_min, x_max, y_max]
+	Rectangle []float64 `json:"rectangle,omitempty"`
+	// Points are the [x, y] vertices of a polygon
+	Points [][]float64 `json:"points,omitempty"`
+	// Normalized is true if the coordinates are fractions of the image size instead of pixels
+	Normalized bool `json:"normalized,omitempty"`
+	// Overlap is the fraction of a bounding box that must be inside the region for the detection to be in it
+	Overlap *float64 `json:"overlap,omitempty"`
+}
+
+// Validate checks that the region is either a rectangle or a polygon, with valid coordinates
+func (r *Region) Validate() error {
+	var coords [][]float64
+	switch {
+	case len(r.Rectangle) > 0 && len(r.Points) > 0:
+		return errors.Errorf("region %v must be either a rectangle or a polygon, not both", r.Name)
+	case len(r.Rectangle) > 0:
+		if len(r.Rectangle) != 4 {
+			return errors.Errorf("rectangle of region %v must be [x_min, y_min, x_max, y_max]", r.Name)
+		}
+		if r.Rectangle[0] >= r.Rectangle[2] || r.Rectangle[1] >= r.Rectangle[3] {
+			return errors.Errorf("rectangle of region %v must have x_min < x_max and y_min < y_max", r.Name)
+		}
+		coords = [][]float64{r.Rectangle[:2], r.Rectangle[2:]}
+	case len(r.Points) > 0:
+		if len(r.Points) < 3 {
+			return errors.Errorf("polygon of region %v must have at least 3 points", r.Name)
+		}
+		for _, p := range r.Points {
+			if len(p) != 2 {
+				return errors.Errorf("points of region %v must be [x, y] points", r.Name)
+			}
+		}
+		coords = r.Points
+	default:
+		return errors.Errorf("region %v must have a rectangle or points", r.Name)
+	}
+	if r.Normalized {
+		for _, p := range coords {
+			if p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+				return errors.Errorf("normalized coordinates of region %v must be between 0.0 and 1.0", r.Name)
+			}
+		}
+	}
+	if r.Overlap != nil && (*r.Overlap < 0 || *r.Overlap > 1) {
+		return errors.Errorf("overlap of region %v must be between 0.0 and 1.0", r.Name)
+	}
+	return nil
+}
+
+// polygon returns the vertices of the region in pixels
+func (r *Region) polygon(bounds image.Rectangle) []point {
+	if len(r.Rectangle) == 4 {
+		lo := toPixels(r.Rectangle[:2], r.Normalized, bounds)
+		hi := toPixels(r.Rectangle[2:], r.Normalized, bounds)
+		return []point{{lo.x, lo.y}, {hi.x, lo.y}, {hi.x, hi.y}, {lo.x, hi.y}}
+	}
+	poly := make([]point, 0, len(r.Points))
+	for _, p := range r.Points {
+		poly = append(poly, toPixels(p, r.Normalized, bounds))
+	}
+	return poly
+}
+
+// contains returns whether enough of the bounding box is inside the polygon of the region.
+// With an overlap of 0, any intersection is enough.
+func (r *Region) contains(poly []point, bb *image.Rectangle) bool {
+	minOverlap := DefaultRegionOverlap
+	if r.Overlap != nil {
+		minOverlap = *r.Overlap
+	}
+	fraction := overlapFraction(bb, poly)
+	return fraction > 0 && fraction >= minOverlap
+}
+
+// overlapFraction returns the fraction of the area of the bounding box that is inside the polygon
+func overlapFraction(bb *image.Rectangle, poly []point) float64 {
+	bbArea := area(*bb)
+	if bbArea == 0 {
+		return 0
+	}
+	return math.Min(polygonArea(clipToRectangle(poly, bb))/bbArea, 1)
+}
+
+// polygonArea returns the area of a simple polygon with the shoelace formula
+func polygonArea(poly []point) float64 {
+	sum := 0.0
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		sum += p.x*q.y - q.x*p.y
+	}
+	return math.Abs(sum) / 2
+}
+
+// clipToRectangle returns the part of the polygon inside the rectangle, with the Sutherland-Hodgman algorithm
+func clipToRectangle(poly []point, rect *image.Rectangle) []point {
+	minX, minY := float64(rect.Min.X), float64(rect.Min.Y)
+	maxX, maxY := float64(rect.Max.X), float64(rect.Max.Y)
+	atX := func(a, b point, x float64) point {
+		return point{x, a.y + (x-a.x)*(b.y-a.y)/(b.x-a.x)}
+	}
+	atY := func(a, b point, y float64) point {
+		return point{a.x + (y-a.y)*(b.x-a.x)/(b.y-a.y), y}
+	}
+	poly = clipEdge(poly, func(p point) bool { return p.x >= minX }, func(a, b point) point { return atX(a, b, minX) })
+	poly = clipEdge(poly, func(p point) bool { return p.x <= maxX }, func(a, b point) point { return atX(a, b, maxX) })
+	poly = clipEdge(poly, func(p point) bool { return p.y >= minY }, func(a, b point) point { return atY(a, b, minY) })
+	poly = clipEdge(poly, func(p point) bool { return p.y <= maxY }, func(a, b point) point { return atY(a, b, maxY) })
+	return poly
+}
+
+// clipEdge keeps the part of the polygon on the inside of one edge of the clipping rectangle
+func clipEdge(poly []point, inside func(point) bool, intersect func(a, b point) point) []point {
+	out := make([]point, 0, len(poly)+1)
+	for i, curr := range poly {
+		prev := poly[(i+len(poly)-1)%len(poly)]
+		switch {
+		case inside(curr) && inside(prev):
+			out = append(out, curr)
+		case inside(curr):
+			out = append(out, intersect(prev, curr), curr)
+		case inside(prev):
+			out = append(out, intersect(prev, curr))
+		}
+	}
+	return out
+}
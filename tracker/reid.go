@@ -0,0 +1,121 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains methods that compute re-identification embeddings of the detections
+package tracker
+
+import (
+	"context"
+	"encoding/base64"
+	"image"
+	"math"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/rimage"
+	"go.viam.com/rdk/services/vision"
+	"go.viam.com/rdk/utils"
+)
+
+const (
+	// ReIDCommand is the DoCommand key used to send a base64 encoded JPEG crop to the re-identification model
+	ReIDCommand = "embed"
+	// ReIDEmbeddingKey is the DoCommand key under which the re-identification model returns the feature vector
+	ReIDEmbeddingKey = "embedding"
+	// weight of the newest frame in the running average of a track's embedding
+	embeddingUpdateRate = 0.1
+)
+
+// Defaults for the re-identification attributes, the number of crops embedded at the same time,
+// and the time (in seconds) after which a call is abandoned
+var (
+	DefaultReIDWeight            = 0.5
+	DefaultReIDRecoveryThreshold = 0.8
+	DefaultReIDConcurrency       = 1
+	DefaultReIDTimeout           = 5.0
+)
+
+// embedTracks asks the re-identification model for a feature vector of the crop of each track, with
+// reidConcurrency calls at the same time. If a call fails or times out, the track is left without embedding.
+func (t *myTracker) embedTracks(ctx context.Context, tracks []*track, img image.Image) []*track {
+	if t.reidModel == nil || len(tracks) == 0 {
+		return tracks
+	}
+	runPool(ctx, len(tracks), t.reidConcurrency, t.reidTimeout, func(callCtx context.Context, i int) {
+		embedding, err := embedCrop(callCtx, cropImageFromDet(img, tracks[i].Det), t.reidModel)
+		if err != nil {
+			t.logger.Warnf("error computing re-identification embedding: %v", err)
+			return
+		}
+		tracks[i].embedding = embedding
+	})
+	return tracks
+}
+
+// embedCrop sends the crop to the re-identification model through DoCommand and returns the normalized feature vector
+func embedCrop(ctx context.Context, crop image.Image, reid vision.Service) ([]float64, error) {
+	imgBytes, err := rimage.EncodeImage(ctx, crop, utils.MimeTypeJPEG)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := reid.DoCommand(ctx, map[string]interface{}{ReIDCommand: base64.StdEncoding.EncodeToString(imgBytes)})
+	if err != nil {
+		return nil, err
+	}
+	var embedding []float64
+	switch v := resp[ReIDEmbeddingKey].(type) {
+	case []float64:
+		embedding = append(embedding, v...)
+	case []interface{}:
+		for _, x := range v {
+			f, ok := x.(float64)
+			if !ok {
+				return nil, errors.Errorf("embedding contains a non-number value %v", x)
+			}
+			embedding = append(embedding, f)
+		}
+	default:
+		return nil, errors.Errorf("expected a list of numbers under %q in the response of the re-identification model", ReIDEmbeddingKey)
+	}
+	if len(embedding) == 0 {
+		return nil, errors.New("re-identification model returned an empty embedding")
+	}
+	return normalize(embedding), nil
+}
+
+// normalize scales a vector to unit length
+func normalize(v []float64) []float64 {
+	norm := 0.0
+	for _, x := range v {
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return v
+	}
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// embeddingSimilarity returns the cosine similarity of two unit vectors, clamped between 0 and 1
+func embeddingSimilarity(e1, e2 []float64) float64 {
+	if len(e1) != len(e2) {
+		return 0
+	}
+	dot := 0.0
+	for i := range e1 {
+		dot += e1[i] * e2[i]
+	}
+	return math.Max(0, math.Min(dot, 1))
+}
+
+// updateEmbedding returns the running average of a track's embedding with a newly observed one
+func updateEmbedding(old, observed []float64) []float64 {
+	if old == nil || len(old) != len(observed) {
+		return observed
+	}
+	out := make([]float64, len(old))
+	for i := range old {
+		out[i] = (1-embeddingUpdateRate)*old[i] + embeddingUpdateRate*observed[i]
+	}
+	return normalize(out)
+}
@@ -3,7 +3,9 @@ package tracker
 
 import (
 	"image"
-	"strings"
+	"time"
+
+	hg "github.com/charles-haynes/munkres"
 )
 
 // IOU returns the intersection over union of 2 rectangles
@@ -17,45 +19,143 @@ func IOU(r1, r2 *image.Rectangle) float64 {
 }
 
 // PredictNextFrame assumes we have two rectangles on frames n-1 and n. We use those
-// to predict the rectangle on frame n+1
+// to predict the rectangle on frame n+1, with the motion model of the tracks and one second between frames.
+//
+// Deprecated: the tracks predict their position with their own motion model, from the time between frames.
 func PredictNextFrame(old, curr image.Rectangle) image.Rectangle {
-	// Calculate the Vx and Vy (assume linear velocity)
-	oldCX, oldCY := float64((old.Min.X+old.Max.X)/2), float64((old.Min.Y+old.Max.Y)/2)
-	currCX, currCY := float64((curr.Min.X+curr.Max.X)/2), float64((curr.Min.Y+curr.Max.Y)/2)
-	newCx, newCy := currCX+(currCX-oldCX), currCY+(currCY-oldCY) // add single frame velocity
-
-	x0, x1 := newCx-float64(curr.Dx()/2), newCx+float64(curr.Dx()/2)
-	y0, y1 := newCy-float64(curr.Dy()/2), newCy+float64(curr.Dy()/2)
-
-	return image.Rect(int(x0), int(y0), int(x1), int(y1))
+	start := time.Unix(0, 0)
+	kf := newKalmanFilter(old, start)
+	kf.update(curr, start.Add(time.Second))
+	return kf.predict(start.Add(2 * time.Second))
 }
 
 // BuildMatchingMatrix sets up a cost matrix for the Hungarian algorithm.
-// We compare the predicted location (if enough track info available) to detected location
-// In this implementation, cost is -IOU between bboxes (b/c solver will find min)
+// We compare the location predicted by the track's motion model at the time of the new detection
+// to the detected location. The cost is given by the configured cost function (-IOU by default),
+// optionally blended with the appearance and re-identification similarities, and is negative for boxes that can be associated
+// (b/c solver will find min)
 func (t *myTracker) BuildMatchingMatrix(oldDetections, newDetections []*track) [][]float64 {
 	h, w := len(oldDetections), len(newDetections)
 	matchMtx := make([][]float64, h)
+	costFunction := t.costFunction
+	if costFunction == nil {
+		costFunction = iouCost{}
+	}
 
 	for i, oldD := range oldDetections {
 		row := make([]float64, w)
-		// Find track. If long enough, make prediction and use that. Otherwise use self
-		label := strings.Join(strings.Split(oldD.Det.Label(), "_")[0:2], "_")
-		track := t.tracks[label]
-		if len(track) >= 2 {
-			pred := PredictNextFrame(
-				*track[len(track)-2].Det.BoundingBox(),
-				*track[len(track)-1].Det.BoundingBox(),
-			)
-			for j, newD := range newDetections {
-				row[j] = -IOU(&pred, newD.Det.BoundingBox())
-			}
-		} else {
-			for j, newD := range newDetections {
-				row[j] = -IOU(oldD.Det.BoundingBox(), newD.Det.BoundingBox())
-			}
+		for j, newD := range newDetections {
+			pred := oldD.predictedBoundingBox(newD.seenAt)
+			row[j] = t.blendAppearance(costFunction.Cost(&pred, newD.Det.BoundingBox()), oldD, newD)
 		}
 		matchMtx[i] = row
 	}
 	return matchMtx
 }
+
+// blendAppearance mixes the geometric cost with the appearance and re-identification similarities
+// of the two tracks, when they are enabled. Boxes that cannot be associated geometrically stay that way,
+// except for lost tracks: they are recovered if one of their descriptors is similar enough to the new detection.
+func (t *myTracker) blendAppearance(cost float64, oldD, newD *track) float64 {
+	geometryWeight := 1.0
+	descriptorCost := 0.0
+	recovered := false
+	if t.appearanceWeight > 0 && oldD.appearance != nil && newD.appearance != nil {
+		similarity := appearanceSimilarity(oldD.appearance, newD.appearance)
+		geometryWeight -= t.appearanceWeight
+		descriptorCost -= t.appearanceWeight * similarity
+		recovered = recovered || similarity >= t.appearanceRecoveryThreshold
+	}
+	if t.reidWeight > 0 && oldD.embedding != nil && newD.embedding != nil {
+		similarity := embeddingSimilarity(oldD.embedding, newD.embedding)
+		geometryWeight -= t.reidWeight
+		descriptorCost -= t.reidWeight * similarity
+		recovered = recovered || similarity >= t.reidRecoveryThreshold
+	}
+	if geometryWeight == 1 {
+		return cost
+	}
+	if cost < 0 {
+		return geometryWeight*cost + descriptorCost
+	}
+	if oldD.state == trackLost && recovered && descriptorCost < 0 {
+		return descriptorCost
+	}
+	return cost
+}
+
+// matchThresholdFor returns the minimum similarity needed to match a track of the given class
+func (t *myTracker) matchThresholdFor(class string) float64 {
+	if threshold, ok := t.classMatchThresholds[class]; ok {
+		return threshold
+	}
+	return t.matchThreshold
+}
+
+// GateMatches rejects the weak assignments found by the Hungarian algorithm. An assignment is kept only if
+// its cost is negative and the similarity (-cost) reaches the match threshold of the old track's class.
+// Rejected old tracks are marked as unmatched (-1), so the new detection they were assigned to will start a fresh track.
+// Only the assignments with a positive similarity below the threshold are counted as rejected matches.
+func (t *myTracker) GateMatches(matches []int, matchMtx [][]float64, oldDets []*track) []int {
+	for oldIdx, newIdx := range matches {
+		if newIdx < 0 || oldIdx >= len(oldDets) {
+			continue
+		}
+		if newIdx >= len(matchMtx[oldIdx]) {
+			matches[oldIdx] = -1
+			continue
+		}
+		class := oldDets[oldIdx].class
+		similarity := -matchMtx[oldIdx][newIdx]
+		if similarity <= 0 {
+			// the boxes have nothing in common, the Hungarian algorithm only paired them to fill the assignment
+			matches[oldIdx] = -1
+			continue
+		}
+		if similarity < t.matchThresholdFor(class) {
+			matches[oldIdx] = -1
+			t.rejectedMatches.Add(1)
+		}
+	}
+	return matches
+}
+
+// MatchLowConfidence is the second stage of the association. The tracks of the last frame (the first nLast
+// of oldDets) that were left unmatched by the confident detections are matched against the low confidence
+// detections. Low confidence detections can keep an existing track alive, but never start a new one.
+// Returns the updated tracks, the tracks that just became stable, and the indices in oldDets of the
+// tracks that were kept alive.
+func (t *myTracker) MatchLowConfidence(matches []int, oldDets []*track, nLast int, lowDets []*track) ([]*track, []*track, map[int]struct{}) {
+	kept := make(map[int]struct{})
+	leftIdx := make([]int, 0)
+	leftDets := make([]*track, 0)
+	for idx := 0; idx < nLast && idx < len(matches); idx++ {
+		if matches[idx] == -1 {
+			leftIdx = append(leftIdx, idx)
+			leftDets = append(leftDets, oldDets[idx])
+		}
+	}
+	if len(leftDets) == 0 || len(lowDets) == 0 {
+		return nil, nil, kept
+	}
+
+	matchMtx := t.BuildMatchingMatrix(leftDets, lowDets)
+	HA, err := hg.NewHungarianAlgorithm(matchMtx)
+	if err != nil {
+		return nil, nil, kept
+	}
+	lowMatches := t.GateMatches(HA.Execute(), matchMtx, leftDets)
+
+	// unmatched low confidence detections are simply dropped
+	notUsed := make(map[int]struct{})
+	for i := range lowDets {
+		notUsed[i] = struct{}{}
+	}
+	updatedTracks, newlyStableTracks, _ := t.updateMatchedTracks(lowMatches, matchMtx, leftDets, lowDets, notUsed)
+	for leftI, lowI := range lowMatches {
+		if lowI != -1 {
+			kept[leftIdx[leftI]] = struct{}{}
+		}
+	}
+	return updatedTracks, newlyStableTracks, kept
+}
@@ -0,0 +1,140 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the life cycle of a track
+package tracker
+
+import (
+	"sync"
+	"time"
+)
+
+// trackState is the step of its life cycle a track is in.
+//
+//	tentative -> confirmed    the track persisted long enough
+//	tentative -> deleted      the track was lost before being confirmed
+//	confirmed -> lost         the track was not matched with any new detection
+//	lost      -> confirmed    the track was matched again
+//	lost      -> deleted      the track was lost for too long
+type trackState int
+
+const (
+	trackTentative trackState = iota
+	trackConfirmed
+	trackLost
+	trackDeleted
+)
+
+// Defaults for how long a lost track is kept: a number of frames and a duration in seconds.
+// 0 means no limit, lost tracks are then only limited by the size of the lost detections buffer.
+var (
+	DefaultMaxAge          = 0
+	DefaultMaxLostDuration = 0.0
+)
+
+// maxTrackEvents is the number of transition events kept in memory
+const maxTrackEvents = 1000
+
+func (s trackState) String() string {
+	switch s {
+	case trackTentative:
+		return "tentative"
+	case trackConfirmed:
+		return "confirmed"
+	case trackLost:
+		return "lost"
+	case trackDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// canTransition returns whether a track can go from one state to another
+func canTransition(from, to trackState) bool {
+	switch from {
+	case trackTentative:
+		return to == trackConfirmed || to == trackDeleted
+	case trackConfirmed:
+		return to == trackLost
+	case trackLost:
+		return to == trackConfirmed || to == trackDeleted
+	default:
+		return false
+	}
+}
+
+// trackEvent is logged every time a track changes state, or changes classification if a transition rule asks for it
+type trackEvent struct {
+	Label string
+	From  string
+	To    string
+	Time  time.Time
+	// Event is the name of the classification transition
+	Event string `json:",omitempty"`
+}
+
+type trackEvents struct {
+	mutex  sync.RWMutex
+	events []trackEvent
+}
+
+// add stores an event, dropping the oldest ones past maxTrackEvents
+func (e *trackEvents) add(event trackEvent) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.events) >= maxTrackEvents {
+		e.events = e.events[1:]
+	}
+	e.events = append(e.events, event)
+}
+
+// count returns the number of stored events
+func (e *trackEvents) count() int {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return len(e.events)
+}
+
+// list returns a copy of the stored events
+func (e *trackEvents) list() []trackEvent {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return append([]trackEvent{}, e.events...)
+}
+
+// transition moves the track to a new state, resets its age in that state and logs the event.
+// Returns false and leaves the track untouched if the transition is not allowed.
+func (t *myTracker) transition(tr *track, to trackState) bool {
+	if !canTransition(tr.state, to) {
+		return false
+	}
+	event := trackEvent{
+		Label: tr.Det.Label(),
+		From:  tr.state.String(),
+		To:    to.String(),
+		Time:  time.Now(),
+	}
+	tr.state = to
+	tr.stateAge = 0
+	tr.stateSince = event.Time
+	t.trackEvents.add(event)
+	if to == trackDeleted {
+		t.leaveZones(tr)
+	}
+	return true
+}
+
+// ageLostTracks increments the age of the tracks waiting in the lost detections buffer, and deletes
+// those that have been lost for more than maxAge frames or more than maxLostDuration (if above 0).
+func (t *myTracker) ageLostTracks() {
+	now := time.Now()
+	expired := t.lostDetectionsBuffer.removeFunc(func(tr *track) bool {
+		tr.stateAge++
+		if t.maxAge > 0 && tr.stateAge > t.maxAge {
+			return true
+		}
+		return t.maxLostDuration > 0 && now.Sub(tr.stateSince) > t.maxLostDuration
+	})
+	for _, tr := range expired {
+		t.transition(tr, trackDeleted)
+	}
+}
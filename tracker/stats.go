@@ -0,0 +1,196 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the bounded statistics kept about the tracker
+package tracker
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize is the default number of bounding boxes kept per track
+var DefaultHistorySize = 100
+
+// DefaultLogSize is the default number of tracked objects kept in the logs
+var DefaultLogSize = 1000
+
+// upper bounds of the latency histogram buckets, the last bucket holds everything above
+var latencyBucketBounds = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// Stages of the tracking loop whose durations are recorded
+const (
+	stageDetect   = "detect"
+	stageClassify = "classify"
+	stageMatch    = "match"
+)
+
+// latencyHistogram records the duration of the tracking loop in fixed-size buckets
+type latencyHistogram struct {
+	mutex   sync.RWMutex
+	buckets []int64
+	count   int64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{buckets: make([]int64, len(latencyBucketBounds)+1)}
+}
+
+// add records one run of the tracking loop
+func (h *latencyHistogram) add(d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	idx := len(latencyBucketBounds)
+	for i, bound := range latencyBucketBounds {
+		if d <= bound {
+			idx = i
+			break
+		}
+	}
+	h.buckets[idx]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// benchmark summarizes the recorded durations
+func (h *latencyHistogram) benchmark() benchmark {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	out := benchmark{
+		Slowest:      float64(h.max),
+		Fastest:      float64(h.min),
+		NumberOfRuns: int(h.count),
+		Histogram:    make(map[string]int64, len(h.buckets)),
+	}
+	if h.count > 0 {
+		out.Average = float64(h.sum / time.Duration(h.count))
+	}
+	for i, n := range h.buckets {
+		if i < len(latencyBucketBounds) {
+			out.Histogram["<="+latencyBucketBounds[i].String()] = n
+		} else {
+			out.Histogram[">"+latencyBucketBounds[i-1].String()] = n
+		}
+	}
+	return out
+}
+
+// stageStats records the duration of each stage of the tracking loop
+type stageStats map[string]*latencyHistogram
+
+func newStageStats() stageStats {
+	return stageStats{
+		stageDetect:   newLatencyHistogram(),
+		stageClassify: newLatencyHistogram(),
+		stageMatch:    newLatencyHistogram(),
+	}
+}
+
+// add records one run of a stage
+func (s stageStats) add(stage string, d time.Duration) {
+	if h, ok := s[stage]; ok {
+		h.add(d)
+	}
+}
+
+// benchmarks summarizes the recorded durations of each stage
+func (s stageStats) benchmarks() map[string]benchmark {
+	out := make(map[string]benchmark, len(s))
+	for stage, h := range s {
+		out[stage] = h.benchmark()
+	}
+	return out
+}
+
+// memoryStats reports the size of the data kept by the tracker
+type memoryStats struct {
+	Tracks          int
+	HistoryEntries  int
+	HistorySize     int
+	LostTracks      int
+	Events          int
+	TrackedObjects  int
+	LogSize         int
+	LatencySamples  int64
+	LatencyBuckets  int
+	LastCollectedAt time.Time
+}
+
+type currentMemoryStats struct {
+	mutex sync.RWMutex
+	stats memoryStats
+}
+
+// appendHistory adds a track to its history, dropping the oldest entries to keep at most size of them
+func appendHistory(history []*track, tr *track, size int) []*track {
+	if size > 0 && len(history) >= size {
+		copy(history, history[len(history)-size+1:])
+		history = history[:size-1]
+	}
+	return append(history, tr)
+}
+
+// collectGarbage forgets the history of the tracks that are neither in the last detections nor in
+// the lost detections buffer, and updates the memory statistics.
+func (t *myTracker) collectGarbage() {
+	alive := make(map[string]struct{}, len(t.lastDetections))
+	for _, tr := range t.lastDetections {
+		alive[getTrackingLabel(tr)] = struct{}{}
+	}
+	lostTracks := 0
+	for _, dets := range t.lostDetectionsBuffer.detections {
+		for _, tr := range dets {
+			alive[getTrackingLabel(tr)] = struct{}{}
+			lostTracks++
+		}
+	}
+	historyEntries := 0
+	for label, history := range t.tracks {
+		if _, ok := alive[label]; !ok {
+			delete(t.tracks, label)
+			continue
+		}
+		historyEntries += len(history)
+	}
+
+	trackedObjects, logSize := t.allFreshObjects.count()
+	t.timeStats.mutex.RLock()
+	latencySamples := t.timeStats.count
+	latencyBuckets := len(t.timeStats.buckets)
+	t.timeStats.mutex.RUnlock()
+
+	t.memStats.mutex.Lock()
+	t.memStats.stats = memoryStats{
+		Tracks:          len(t.tracks),
+		HistoryEntries:  historyEntries,
+		HistorySize:     t.historySize,
+		LostTracks:      lostTracks,
+		Events:          t.trackEvents.count(),
+		TrackedObjects:  trackedObjects,
+		LogSize:         logSize,
+		LatencySamples:  latencySamples,
+		LatencyBuckets:  latencyBuckets,
+		LastCollectedAt: time.Now(),
+	}
+	t.memStats.mutex.Unlock()
+}
@@ -1,10 +1,9 @@
 package tracker
 
 import (
-	"strconv"
-	"strings"
+	"image"
+	"time"
 
-	"github.com/pkg/errors"
 	"go.viam.com/rdk/vision/classification"
 	objdet "go.viam.com/rdk/vision/objectdetection"
 )
@@ -16,57 +15,112 @@ type track struct {
 	detClassification classification.Classification
 	persistenceLimit  int
 	persistenceCount  int
-	stable            bool
+	// state is the step of its life cycle the track is in, stateAge the number of frames it has been in it,
+	// and stateSince the time it entered it (for a lost track, the time it was lost)
+	state      trackState
+	stateAge   int
+	stateSince time.Time
+	// seenAt is the time at which the bounding box was detected, firstSeen the time the track was started
+	seenAt    time.Time
+	firstSeen time.Time
+	// kf predicts the motion of the bounding box, it is started once the track is named
+	kf *kalmanFilter
+	// appearance is the color histogram of the track, averaged over the frames it was seen in
+	appearance []float64
+	// embedding is the unit feature vector of the track given by the re-identification model, averaged over frames
+	embedding []float64
+	// rawLabel is the label given by the detector, if it was replaced by an alias
+	rawLabel string
+	// identity of the track once it is named: its class, its number among the tracks of that class,
+	// the time it was named, its latest classification and a unique id. The label of the detection is built from them.
+	class          string
+	id             int
+	namedAt        time.Time
+	classification string
+	uuid           string
+	// classifications of the latest frames, the share of the votes of each classification,
+	// and the classification waiting to replace the current one with ClassificationPolicyHysteresis
+	classVotes            []classVote
+	classificationVotes   map[string]float64
+	pendingClassification string
+	pendingCount          int
+	// frames the track was seen in since it was last classified, and its box at the time, for the classify policies
+	framesSinceClassified int
+	classifiedBox         image.Rectangle
 }
 
-// newTrack turns a bounding box into a new track with a fresh persistence counter
+// newTrack turns a bounding box into a new track with a fresh persistence counter, seen now
 func newTrack(det objdet.Detection, lim int) *track {
-	return &track{det, nil, lim, 0, false}
+	return newTrackSeenAt(det, lim, time.Now())
+}
+
+// newTrackSeenAt turns a bounding box into a new track with a fresh persistence counter, seen in the frame
+// captured at seenAt
+func newTrackSeenAt(det objdet.Detection, lim int, seenAt time.Time) *track {
+	tr := &track{
+		Det:              det,
+		persistenceLimit: lim,
+		seenAt:           seenAt,
+		stateSince:       seenAt,
+	}
+	if d, ok := det.(*aliasedDetection); ok {
+		tr.rawLabel = d.rawLabel
+	}
+	return tr
 }
 
 // newTracks turns a slice of bounding boxes into a track with a fresh persistence counter
 func newTracks(dets []objdet.Detection, lim int) []*track {
+	return newTracksSeenAt(dets, lim, time.Now())
+}
+
+// newTracksSeenAt turns a slice of bounding boxes, detected in the frame captured at seenAt, into tracks
+// with a fresh persistence counter. The motion model uses seenAt, so the latency of the detector does not
+// change the time between frames.
+func newTracksSeenAt(dets []objdet.Detection, lim int, seenAt time.Time) []*track {
 	tracks := make([]*track, 0, len(dets))
 	for _, d := range dets {
-		tracks = append(tracks, newTrack(d, lim))
+		tracks = append(tracks, newTrackSeenAt(d, lim, seenAt))
 	}
 	return tracks
 }
 
 // clone will duplicate all the properties of the track
 func (tr *track) clone() *track {
-	return &track{
-		tr.Det,
+	out := *tr
+	out.kf = tr.kf.clone()
+	return &out
+}
 
-		tr.detClassification,
-		tr.persistenceLimit,
-		tr.persistenceCount,
-		tr.stable,
+// predictedBoundingBox returns where the track is expected to be at time ts.
+// Tracks without a motion model are expected to stay in place.
+func (tr *track) predictedBoundingBox(ts time.Time) image.Rectangle {
+	if tr.kf == nil {
+		return *tr.Det.BoundingBox()
 	}
+	return tr.kf.predict(ts)
 }
 
 // isStable returns that the track has persisted long enough to count as stable
 func (tr *track) isStable() bool {
-	return tr.stable
+	return tr.state == trackConfirmed || tr.state == trackLost
 }
 
-// addPersistence add to the persistence counter
-func (tr *track) addPersistence() {
-	if tr.stable {
-		return
+// addPersistence add to the persistence counter of a tentative track,
+// and returns whether it has persisted long enough to be confirmed
+func (tr *track) addPersistence() bool {
+	if tr.state != trackTentative {
+		return false
 	}
 	tr.persistenceCount += 1
-	if tr.persistenceCount >= tr.persistenceLimit {
-		tr.stable = true
-	}
+	return tr.persistenceCount >= tr.persistenceLimit
 }
 
-func (tr *track) addClassificationToLabel(c string) *track {
+func (tr *track) addClassificationToLabel(c string, format *labelFormat) *track {
 	if tr.detClassification != nil {
-		// Find it all up to and including the date and time
-		parts := strings.Split(tr.Det.Label(), "_")
-		labelNoClass := strings.Join(parts[:4], "_")
-		return ReplaceLabel(tr, labelNoClass+"_"+c)
+		out := tr.clone()
+		out.classification = c
+		return ReplaceLabel(out, format.label(out))
 	}
 	return tr
 }
@@ -75,7 +129,7 @@ func (tr *track) addClassificationToLabel(c string) *track {
 func getStableDetections(tracks []*track) []objdet.Detection {
 	dets := make([]objdet.Detection, 0, len(tracks))
 	for _, tr := range tracks {
-		if tr.stable {
+		if tr.isStable() {
 			dets = append(dets, tr.Det)
 		}
 	}
@@ -89,21 +143,113 @@ type trackedObject struct {
 	Id             int
 	Time           string
 	Classification string
+	// ZoneDwellS is the total time (in seconds) the object spent in each zone it left
+	ZoneDwellS map[string]float64 `json:",omitempty"`
+	// RawLabel is the label given by the detector, if it was mapped to the class through an alias
+	RawLabel string `json:",omitempty"`
+	// ClassificationVotes is the share of the votes of each classification of the track
+	ClassificationVotes map[string]float64 `json:",omitempty"`
 }
 
-func newTrackedObjectFromLabel(label string) (trackedObject, error) {
-	// Full label is in the form "object_count_date_time_classification"
-	parts := strings.Split(label, "_")
-	id, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return trackedObject{}, errors.Wrapf(err, "unable to parse label %v", label)
-	}
+// newTrackedObject returns the log info of a named track
+func newTrackedObject(tr *track) trackedObject {
 	return trackedObject{
-		FullLabel:      label,
-		Label:          parts[0],
-		Id:             id,
-		Time:           strings.Join(parts[2:4], "_"),
-		Classification: strings.Join(parts[4:], "_"),
-	}, nil
+		FullLabel:           tr.Det.Label(),
+		Label:               tr.class,
+		Id:                  tr.id,
+		Time:                tr.namedAt.Format(timestampFormat),
+		Classification:      tr.classification,
+		RawLabel:            tr.rawLabel,
+		ClassificationVotes: tr.classificationVotes,
+	}
+}
+
+// stableDetections returns the detections of the stable tracks. With LabelModeClass, the detections are
+// labelled with the label given by the detector (before any alias) and the labels of their tracks are also
+// returned, in the same order.
+func (t *myTracker) stableDetections() ([]objdet.Detection, []string) {
+	t.currDetections.mutex.RLock()
+	defer t.currDetections.mutex.RUnlock()
+	if t.labelMode != LabelModeClass {
+		return getStableDetections(t.currDetections.detections), nil
+	}
+	dets := make([]objdet.Detection, 0, len(t.currDetections.detections))
+	labels := make([]string, 0, len(t.currDetections.detections))
+	for _, tr := range t.currDetections.detections {
+		if tr.isStable() {
+			label := tr.class
+			if tr.rawLabel != "" {
+				label = tr.rawLabel
+			}
+			dets = append(dets, objdet.NewDetection(*tr.Det.BoundingBox(), tr.Det.Score(), label))
+			labels = append(labels, tr.Det.Label())
+		}
+	}
+	return dets, labels
+}
 
+// updateLog applies the change to the logs of the tracked object with the given tracking label
+func (t *myTracker) updateLog(label string, change func(obj *trackedObject)) {
+	t.allFreshObjects.mutex.Lock()
+	defer t.allFreshObjects.mutex.Unlock()
+	if i, ok := t.allFreshObjects.index[label]; ok {
+		change(&t.allFreshObjects.objects[i])
+	}
+}
+
+// add logs a tracked object, replacing the oldest log once the buffer is full
+func (o *allObjects) add(obj trackedObject) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.push(obj)
+}
+
+func (o *allObjects) push(obj trackedObject) {
+	if o.index == nil {
+		o.index = make(map[string]int)
+	}
+	label := formatTrackingLabel(obj.Label, obj.Id)
+	if o.size == 0 || len(o.objects) < o.size {
+		o.index[label] = len(o.objects)
+		o.objects = append(o.objects, obj)
+		return
+	}
+	oldest := o.objects[o.next]
+	if oldestLabel := formatTrackingLabel(oldest.Label, oldest.Id); o.index[oldestLabel] == o.next {
+		delete(o.index, oldestLabel)
+	}
+	o.objects[o.next] = obj
+	o.index[label] = o.next
+	o.next = (o.next + 1) % o.size
+}
+
+// list returns a copy of the logs, from the oldest to the latest
+func (o *allObjects) list() []trackedObject {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	out := make([]trackedObject, 0, len(o.objects))
+	out = append(out, o.objects[o.next:]...)
+	return append(out, o.objects[:o.next]...)
+}
+
+// count returns the number of logs and the maximum number of logs kept
+func (o *allObjects) count() (int, int) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return len(o.objects), o.size
+}
+
+// resize changes the maximum number of logs kept, keeping the latest ones
+func (o *allObjects) resize(size int) {
+	objects := o.list()
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if size > 0 && len(objects) > size {
+		objects = objects[len(objects)-size:]
+	}
+	o.objects = make([]trackedObject, 0, len(objects))
+	o.size, o.next, o.index = size, 0, make(map[string]int, len(objects))
+	for _, obj := range objects {
+		o.push(obj)
+	}
 }
@@ -41,11 +41,18 @@ var (
 	DefaultMaxFrequency        = 10.0
 	DefaultTriggerCoolDown     = 5.0
 	DefaultBufferSize          = 30
+	DefaultMatchThreshold      = 0.0
 )
 
+// allObjects keeps the logs of the latest tracked objects in a ring buffer, indexed by tracking label
 type allObjects struct {
 	mutex   sync.RWMutex
 	objects []trackedObject
+	// size is the maximum number of logs kept, 0 for no limit, and next is the position of the oldest
+	// log once the buffer is full
+	size  int
+	next  int
+	index map[string]int
 }
 
 type currentDetections struct {
@@ -82,15 +89,82 @@ type myTracker struct {
 
 	cam                 camera.Camera
 	camName             string
-	detector            vision.Service
+	detectors           []ensembleMember
 	pizzaClassifier     vision.Service
 	frequency           float64
 	minConfidence       float64
 	chosenLabels        map[string]float64
 	classCounter        map[string]int
+	tracksMutex         sync.RWMutex
 	tracks              map[string][]*track
-	timeStats           []time.Duration
+	timeStats           *latencyHistogram
+	stageStats          stageStats
 	minTrackPersistence int
+	costFunctionName    string
+	costFunction        CostFunction
+	// minimum similarity (-cost) for a match to be accepted, overridable per class
+	matchThreshold       float64
+	classMatchThresholds map[string]float64
+	rejectedMatches      atomic.Int64
+	// detections between lowConfidenceThreshold and minConfidence are used in a second association stage
+	useLowConfidence       bool
+	lowConfidenceThreshold float64
+	// number of frames a lost track is kept for before being deleted, 0 for no limit
+	maxAge          int
+	maxLostDuration time.Duration
+	trackEvents     trackEvents
+	// number of bounding boxes kept in the history of each track
+	historySize int
+	memStats    currentMemoryStats
+	// weight of the appearance in the matching cost, 0 disables appearance matching
+	appearanceWeight            float64
+	appearanceRecoveryThreshold float64
+	// optional re-identification model giving a feature vector per crop
+	reidModel             vision.Service
+	reidWeight            float64
+	reidRecoveryThreshold float64
+	// by how many workers and with which timeout the crops are sent to the re-identification model
+	reidConcurrency int
+	reidTimeout     time.Duration
+	// counts of the stable tracks crossing the configured lines
+	lineCounter *lineCounter
+	// enter and exit events of the stable tracks in the configured zones
+	zoneMonitor *zoneMonitor
+	// detections are only tracked if they are in an include region (if any) and in no exclude region
+	includeRegions []Region
+	excludeRegions []Region
+	// constraints on the size, shape and position of the bounding boxes, per class
+	geometryConstraints map[string]GeometryConstraints
+	// optional non-maximum suppression or weighted box fusion of the overlapping detections
+	duplicateFilter objdet.Postprocessor
+	// overlap above which the detections of an ensemble of detectors are fused
+	ensembleIOUThreshold float64
+	// canonical class of the raw labels of the detectors, keyed by lowercase raw label
+	labelAliases map[string]string
+	// template of the labels of the tracks, and whether the output detections use it or the class
+	labelFormat *labelFormat
+	labelMode   string
+	// optional state file of the class counters, and local time of the day at which they are reset
+	counterStateFile string
+	counterResetTime *dailyTime
+	countersReset    time.Time
+	countersDirty    bool
+	countersSavedAt  time.Time
+	// a track number was issued since the counters were last written, they are written before the track is output
+	countersIssued bool
+	// policy choosing the classification of the tracks from their latest classifications
+	classificationVoter *classificationVoter
+	// rules applied when the classification of a track changes, and the events they logged
+	classificationTransitions []ClassificationTransition
+	classificationEvents      trackEvents
+	// which tracks are classified on each frame
+	classifyPolicy *classifyPolicy
+	// how the crops are sent to the classifier: by how many workers, with which timeout, or as one mosaic
+	classifierConcurrency int
+	classifierTimeout     time.Duration
+	classifierMosaic      bool
+	// how the crops given to the classifier are cut, exactly the bounding box if nil
+	cropper *cropper
 }
 
 func newTracker(ctx context.Context, deps resource.Dependencies, conf resource.Config, logger logging.Logger) (vision.Service, error) {
@@ -113,6 +187,9 @@ func newTracker(ctx context.Context, deps resource.Dependencies, conf resource.C
 	if err := t.Reconfigure(ctx, deps, conf); err != nil {
 		return nil, err
 	}
+	if err := t.loadCounters(time.Now()); err != nil {
+		return nil, err
+	}
 
 	//Default value for persistence
 	if t.minTrackPersistence == 0 {
@@ -129,26 +206,39 @@ func newTracker(ctx context.Context, deps resource.Dependencies, conf resource.C
 
 	// Do the first pass to populate the first set of 2 detections.
 	starterDets := make([][]*track, 2)
+	var img image.Image
 	stream, err := t.cam.Stream(t.cancelContext, nil)
 	if err != nil {
 		return nil, err
 	}
 	for i := 0; i < 2; i++ {
-		img, _, err := stream.Next(t.cancelContext)
+		img, _, err = stream.Next(t.cancelContext)
 		if err != nil {
 			return nil, err
 		}
-		detections, err := t.detector.Detections(ctx, img, nil)
+		capturedAt := time.Now()
+		detections, err := t.detect(ctx, img)
 		if err != nil {
 			return nil, err
 		}
+		detections = t.filterGeometry(detections, img.Bounds())
 		filteredDets := FilterDetections(t.chosenLabels, detections, t.minConfidence)
-		tracks := newTracks(filteredDets, t.minTrackPersistence)
-		classifiedTracks := classifyTracks(ctx, tracks, img, t.pizzaClassifier, t.logger)
-		starterDets[i] = classifiedTracks
+		if t.duplicateFilter != nil {
+			filteredDets = t.duplicateFilter(filteredDets)
+		}
+		tracks := newTracksSeenAt(filteredDets, t.minTrackPersistence, capturedAt)
+		if t.appearanceWeight > 0 {
+			tracks = describeTracks(tracks, img)
+		}
+		tracks = t.embedTracks(ctx, tracks, img)
+		if t.classifyPolicy.everyFrame() {
+			tracks = t.classifyTracks(ctx, tracks, img)
+		}
+		starterDets[i] = tracks
 	}
 	filteredOld := starterDets[0]
 	filteredNew := starterDets[1]
+	t.resetCountersIfDue(time.Now())
 	// Rename (from scratch)
 	renamedOld := make([]*track, 0, len(filteredOld))
 	for _, det := range filteredOld {
@@ -161,25 +251,34 @@ func newTracker(ctx context.Context, deps resource.Dependencies, conf resource.C
 	if err != nil {
 		return nil, err
 	}
-	matches := HA.Execute()
+	matches := t.GateMatches(HA.Execute(), matchMtx, renamedOld)
+	t.classifyDue(ctx, matches, renamedOld, filteredNew, img)
+
+	// Rename from temporal matches. New det copies old det's label
+	renamedNew, newlyStable, _ := t.RenameFromMatches(matches, matchMtx, renamedOld, filteredNew)
+
 	var lostDetections []*track
 	for idx, _ := range matches {
 		if matches[idx] == -1 {
 			// if lost detection is not stable, discard it
-			if renamedOld[idx].isStable() {
+			if t.transition(renamedOld[idx], trackLost) {
 				lostDetections = append(lostDetections, renamedOld[idx])
+			} else {
+				t.transition(renamedOld[idx], trackDeleted)
+				delete(t.tracks, getTrackingLabel(renamedOld[idx]))
 			}
 		}
 	}
-	t.lostDetectionsBuffer.AppendDets(lostDetections)
-
-	// Rename from temporal matches. New det copies old det's label
-	renamedNew, newlyStable, _ := t.RenameFromMatches(matches, matchMtx, renamedOld, filteredNew)
+	t.appendLostTracks(lostDetections)
 	if len(newlyStable) > 0 {
 		t.trigger()
 	}
 	renamedNew = append(renamedNew, newlyStable...)
+	t.countCrossings(renamedNew, img.Bounds())
+	t.updateZones(renamedNew, img.Bounds())
 	t.lastDetections = renamedNew
+	t.collectGarbage()
+	t.flushCounters()
 	t.currDetections.mutex.Lock()
 	t.currDetections.detections = renamedNew
 	t.currDetections.mutex.Unlock()
@@ -215,71 +314,147 @@ func (t *myTracker) run(stream gostream.VideoStream, cancelableCtx context.Conte
 				t.logger.Errorf("got nil image")
 				continue
 			}
-			detections, err := t.detector.Detections(cancelableCtx, img, nil)
+			// the frame time is taken before the detector runs, so its latency does not end up in the motion model
+			capturedAt := time.Now()
+			stageStart := capturedAt
+			detections, err := t.detect(cancelableCtx, img)
 			if err != nil {
 				t.logger.Errorf("can't get detections. got err: %s", err)
 				continue
 			}
+			// detections outside of the regions of interest or with an unlikely shape are dropped before any other filter
+			detections = t.filterGeometry(detections, img.Bounds())
 			filteredDets := FilterDetections(t.chosenLabels, detections, t.minConfidence)
+			// overlapping detections of the same object are suppressed or merged so they do not start several tracks
+			if t.duplicateFilter != nil {
+				filteredDets = t.duplicateFilter(filteredDets)
+			}
+
+			t.stageStats.add(stageDetect, time.Since(stageStart))
 
 			// all new tracks get a fresh persistence counter
-			filteredNew := newTracks(filteredDets, t.minTrackPersistence)
+			filteredNew := newTracksSeenAt(filteredDets, t.minTrackPersistence, capturedAt)
+			if t.appearanceWeight > 0 {
+				filteredNew = describeTracks(filteredNew, img)
+			}
+			filteredNew = t.embedTracks(cancelableCtx, filteredNew, img)
 
-			// Here we will classify the cropped pizza detections and add that to the label
-			classifiedNew := classifyTracks(cancelableCtx, filteredNew, img, t.pizzaClassifier, t.logger)
+			// Here we will classify the cropped pizza detections and add that to the label,
+			// unless the classify policy only classifies some of the tracks after matching
+			classifiedNew := filteredNew
+			if t.classifyPolicy.everyFrame() {
+				stageStart = time.Now()
+				classifiedNew = t.classifyTracks(cancelableCtx, filteredNew, img)
+				t.stageStats.add(stageClassify, time.Since(stageStart))
+			}
+
+			// low confidence detections are only used to keep existing tracks alive, they are not classified
+			var lowConfidenceNew []*track
+			if t.useLowConfidence {
+				lowConfidenceDets := FilterLowConfidenceDetections(t.chosenLabels, detections, t.minConfidence, t.lowConfidenceThreshold)
+				// overlapping low confidence detections would otherwise keep several tracks alive for one object
+				if t.duplicateFilter != nil {
+					lowConfidenceDets = t.duplicateFilter(lowConfidenceDets)
+				}
+				lowConfidenceNew = newTracksSeenAt(lowConfidenceDets, t.minTrackPersistence, capturedAt)
+				if t.appearanceWeight > 0 {
+					lowConfidenceNew = describeTracks(lowConfidenceNew, img)
+				}
+				lowConfidenceNew = t.embedTracks(cancelableCtx, lowConfidenceNew, img)
+			}
 
+			// The tracks are only modified by this loop, they can be matched before taking the lock
+			stageStart = time.Now()
 			// Store oldDetection and lost detections in allDetections
-			allDetections := t.lastDetections
+			allDetections := append([]*track{}, t.lastDetections...)
 			for _, dets := range t.lostDetectionsBuffer.detections {
 				allDetections = append(allDetections, dets...)
 			}
 			// Build and solve cost matrix via Munkres' method
 			matchMtx := t.BuildMatchingMatrix(allDetections, classifiedNew)
 			HA, _ := hg.NewHungarianAlgorithm(matchMtx)
-			matches := HA.Execute()
+			matches := t.GateMatches(HA.Execute(), matchMtx, allDetections)
+			matchTook := time.Since(stageStart)
+			// the classify policy asks for the classification of some of the matched and new tracks, the classifier
+			// is called outside of the lock so DoCommand is not blocked while it runs
+			if !t.classifyPolicy.everyFrame() {
+				stageStart = time.Now()
+				t.classifyDue(cancelableCtx, matches, allDetections, classifiedNew, img)
+				t.stageStats.add(stageClassify, time.Since(stageStart))
+			}
+
+			// The tracks are only modified while holding the lock, so DoCommand can read them
+			t.tracksMutex.Lock()
+			stageStart = time.Now()
+			t.resetCountersIfDue(start)
+			// Returns a new set of detections, from matching allDetections with the filteredNew
+			// All three outputs must be summed together to get the full set of new detections
+			renamedNew, newlyStable, freshDets := t.RenameFromMatches(matches, matchMtx, allDetections, classifiedNew)
+			// Second stage: tracks from the last frame that are still unmatched get a chance
+			// to be matched with the low confidence detections
+			lowUpdated, lowNewlyStable, keptAlive := t.MatchLowConfidence(matches, allDetections, len(t.lastDetections), lowConfidenceNew)
+			renamedNew = append(renamedNew, lowUpdated...)
+			newlyStable = append(newlyStable, lowNewlyStable...)
+			t.stageStats.add(stageMatch, matchTook+time.Since(stageStart))
+
+			// Lost tracks that were matched again are no longer waiting in the buffer
+			recovered := make(map[*track]struct{})
+			for idx := len(t.lastDetections); idx < len(allDetections) && idx < len(matches); idx++ {
+				if matches[idx] != -1 {
+					recovered[allDetections[idx]] = struct{}{}
+				}
+			}
+			t.lostDetectionsBuffer.removeFunc(func(tr *track) bool {
+				_, ok := recovered[tr]
+				return ok
+			})
+			t.ageLostTracks()
+
 			// Store the lost detections in the buffer, drop lost detections
 			// if they were not considered stable
 			var lostDetections []*track
 			for idx, _ := range t.lastDetections {
+				if _, ok := keptAlive[idx]; ok {
+					continue
+				}
 				if matches[idx] == -1 {
-					if t.lastDetections[idx].isStable() {
-						lostDetections = append(lostDetections, t.lastDetections[idx])
-					} else {
+					if !t.transition(t.lastDetections[idx], trackLost) {
 						// drop lost detections from track list as well
+						t.transition(t.lastDetections[idx], trackDeleted)
 						countLabel := getTrackingLabel(t.lastDetections[idx])
 						delete(t.tracks, countLabel)
+						continue
 					}
+					lostDetections = append(lostDetections, t.lastDetections[idx])
 				}
 			}
-			t.lostDetectionsBuffer.AppendDets(lostDetections)
-			// Returns a new set of detections, from matching allDetections with the filteredNew
-			// All three outputs must be summed together to get the full set of new detections
-			renamedNew, newlyStable, freshDets := t.RenameFromMatches(matches, matchMtx, allDetections, classifiedNew)
+			t.appendLostTracks(lostDetections)
 			if len(newlyStable) > 0 {
 				//trigger classification and schedule "untrigger"
 				t.trigger()
 
 				// add the detections to the logs
-				t.allFreshObjects.mutex.Lock()
 				for _, det := range newlyStable {
-					to, err := newTrackedObjectFromLabel(det.Det.Label())
-					if err != nil {
-						t.logger.Error(err)
-					}
-					t.allFreshObjects.objects = append(t.allFreshObjects.objects, to)
+					t.allFreshObjects.add(newTrackedObject(det))
 				}
-				t.allFreshObjects.mutex.Unlock()
 			}
 			renamedNew = append(renamedNew, newlyStable...)
+			t.countCrossings(renamedNew, img.Bounds())
+			t.updateZones(renamedNew, img.Bounds())
 			renamedNew = append(renamedNew, freshDets...)
 			t.lastDetections = renamedNew
+			t.collectGarbage()
+			counters := t.encodeCounters(time.Now(), false)
+			t.tracksMutex.Unlock()
+			// the numbers of the new tracks are saved before the tracks are output
+			t.saveCounters(counters)
 			t.currDetections.mutex.Lock()
 			t.currDetections.detections = renamedNew
 			t.currDetections.mutex.Unlock()
 			t.currImg.Store(&img)
 
 			took := time.Since(start)
-			t.timeStats = append(t.timeStats, took)
+			t.timeStats.add(took)
 			waitFor := time.Duration((1/t.frequency)*float64(time.Second)) - took
 			if waitFor > time.Microsecond {
 				select {
@@ -327,9 +502,72 @@ type Config struct {
 	ChosenLabels        map[string]float64 `json:"chosen_labels"`
 	MaxFrequency        float64            `json:"max_frequency_hz"`
 	MinConfidence       *float64           `json:"min_confidence,omitempty"`
+	LowConfidence       *float64           `json:"low_confidence_threshold,omitempty"`
 	TriggerCoolDown     *float64           `json:"trigger_cool_down_s,omitempty"`
 	BufferSize          int                `json:"buffer_size,omitempty"`
+	MaxAge              *int               `json:"max_age,omitempty"`
+	MaxLostDuration     *float64           `json:"max_lost_duration_s,omitempty"`
+	MaxLostTracks       int                `json:"max_lost_tracks,omitempty"`
+	HistorySize         int                `json:"history_size,omitempty"`
+	LogSize             int                `json:"log_size,omitempty"`
 	MinTrackPersistence int                `json:"min_track_persistence"`
+	CostFunction        string             `json:"cost_function,omitempty"`
+	MatchThreshold      *float64           `json:"match_threshold,omitempty"`
+	ClassMatchThreshold map[string]float64 `json:"class_match_thresholds,omitempty"`
+	AppearanceWeight    *float64           `json:"appearance_weight,omitempty"`
+	AppearanceRecovery  *float64           `json:"appearance_recovery_threshold,omitempty"`
+	ReIDModelName       string             `json:"reid_model_name,omitempty"`
+	ReIDWeight          *float64           `json:"reid_weight,omitempty"`
+	ReIDRecovery        *float64           `json:"reid_recovery_threshold,omitempty"`
+	CountingLines       []CountingLine     `json:"counting_lines,omitempty"`
+	Zones               []Zone             `json:"zones,omitempty"`
+	IncludeRegions      []Region           `json:"include_regions,omitempty"`
+	ExcludeRegions      []Region           `json:"exclude_regions,omitempty"`
+
+	// constraints per class, the constraints of AllClasses apply to the classes without their own
+	GeometryFilters  map[string]GeometryConstraints `json:"geometry_filters,omitempty"`
+	NMSThreshold     *float64                       `json:"nms_iou_threshold,omitempty"`
+	NMSClassAgnostic bool                           `json:"nms_class_agnostic,omitempty"`
+	NMSMethod        string                         `json:"nms_method,omitempty"`
+
+	// ensemble of detectors used instead of detector_name, their detections are fused with weighted box fusion
+	DetectorNames        []DetectorConfig  `json:"detector_names,omitempty"`
+	EnsembleIOUThreshold *float64          `json:"ensemble_iou_threshold,omitempty"`
+	LabelAliases         map[string]string `json:"label_aliases,omitempty"`
+
+	// template of the labels of the tracks, e.g. "{class}#{uuid}", and whether the output detections use it
+	LabelFormat string `json:"label_format,omitempty"`
+	LabelMode   string `json:"label_mode,omitempty"`
+
+	// the class counters can be saved to a state file, relative to the data directory of the module, and reset every day
+	PersistCounters  bool   `json:"persist_counters,omitempty"`
+	CounterStateFile string `json:"counter_state_file,omitempty"`
+	CounterResetTime string `json:"counter_reset_time,omitempty"`
+
+	// how the classification of a track is chosen from the classifications of its latest frames
+	ClassificationPolicy     string `json:"classification_policy,omitempty"`
+	ClassificationWindow     int    `json:"classification_window,omitempty"`
+	ClassificationHysteresis int    `json:"classification_hysteresis,omitempty"`
+
+	// rules applied when the classification of a track changes, DefaultClassificationTransitions if not set
+	ClassificationTransitions []ClassificationTransition `json:"classification_transitions,omitempty"`
+
+	// when the tracks are classified, every frame by default
+	ClassifyPolicy       string   `json:"classify_policy,omitempty"`
+	ClassifyEveryNFrames int      `json:"classify_every_n_frames,omitempty"`
+	ClassifyIOUDelta     *float64 `json:"classify_iou_delta,omitempty"`
+
+	// the crops are classified by a pool of workers, or all at once in a mosaic image
+	ClassifierConcurrency int      `json:"classifier_concurrency,omitempty"`
+	ClassifierTimeout     *float64 `json:"classifier_timeout_s,omitempty"`
+	ClassifierMosaic      bool     `json:"classifier_mosaic,omitempty"`
+
+	// padding, letterboxing and size of the crops given to the classifier
+	ClassifierCrop *CropOptions `json:"classifier_crop,omitempty"`
+
+	// the crops are sent to the re-identification model by a pool of workers
+	ReIDConcurrency int      `json:"reid_concurrency,omitempty"`
+	ReIDTimeout     *float64 `json:"reid_timeout_s,omitempty"`
 }
 
 // Validate validates the config and returns implicit dependencies,
@@ -342,24 +580,39 @@ func (cfg *Config) Validate(path string) ([]string, error) {
 	if cfg.CameraName == "" {
 		return nil, fmt.Errorf(`expected "camera_name" attribute for object tracker %q`, path)
 	}
-	if cfg.DetectorName == "" {
-		return nil, fmt.Errorf(`expected "detector_name" attribute for object tracker %q`, path)
+	if cfg.DetectorName == "" && len(cfg.DetectorNames) == 0 {
+		return nil, fmt.Errorf(`expected "detector_name" or "detector_names" attribute for object tracker %q`, path)
 	}
-
-	if cfg.PizzaClassifierName == "" {
-		return []string{cfg.CameraName, cfg.DetectorName}, nil
+	if cfg.DetectorName != "" && len(cfg.DetectorNames) > 0 {
+		return nil, fmt.Errorf(`expected only one of "detector_name" and "detector_names" attributes for object tracker %q`, path)
 	}
 
 	// Return the resource names so that newTracker can access them as dependencies.
-	return []string{cfg.CameraName, cfg.DetectorName, cfg.PizzaClassifierName}, nil
+	deps := []string{cfg.CameraName}
+	if cfg.DetectorName != "" {
+		deps = append(deps, cfg.DetectorName)
+	}
+	for _, d := range cfg.DetectorNames {
+		if d.Name == "" {
+			return nil, fmt.Errorf(`expected a "name" for each of the "detector_names" of object tracker %q`, path)
+		}
+		deps = append(deps, d.Name)
+	}
+	if cfg.PizzaClassifierName != "" {
+		deps = append(deps, cfg.PizzaClassifierName)
+	}
+	if cfg.ReIDModelName != "" {
+		deps = append(deps, cfg.ReIDModelName)
+	}
+	return deps, nil
 }
 
 // Reconfigure reconfigures with new settings.
 func (t *myTracker) Reconfigure(ctx context.Context, deps resource.Dependencies, conf resource.Config) error {
-	var timeList []time.Duration
 	t.cam = nil
-	t.detector = nil
-	t.timeStats = timeList
+	t.detectors = nil
+	t.timeStats = newLatencyHistogram()
+	t.stageStats = newStageStats()
 
 	// This takes the generic resource.Config passed down from the parent and converts it to the
 	// model-specific (aka "native") Config structure defined, above making it easier to directly access attributes.
@@ -385,6 +638,49 @@ func (t *myTracker) Reconfigure(ctx context.Context, deps resource.Dependencies,
 	} else {
 		t.lostDetectionsBuffer = newTracksBuffer(DefaultBufferSize)
 	}
+	if trackerConfig.MaxLostTracks < 0 {
+		return errors.New("max_lost_tracks cannot be less than 0")
+	}
+	t.lostDetectionsBuffer.maxTracks = trackerConfig.MaxLostTracks
+
+	//config history size
+	if trackerConfig.HistorySize < 0 {
+		return errors.New("history_size cannot be less than 0")
+	}
+	t.historySize = trackerConfig.HistorySize
+	if t.historySize == 0 {
+		t.historySize = DefaultHistorySize
+	}
+
+	//config number of logs
+	if trackerConfig.LogSize < 0 {
+		return errors.New("log_size cannot be less than 0")
+	}
+	logSize := trackerConfig.LogSize
+	if logSize == 0 {
+		logSize = DefaultLogSize
+	}
+	t.allFreshObjects.resize(logSize)
+
+	//config max age of lost tracks
+	if trackerConfig.MaxAge != nil {
+		if *trackerConfig.MaxAge < 0 {
+			return errors.New("max_age is a number of frames and cannot be less than 0")
+		}
+		t.maxAge = *trackerConfig.MaxAge
+	} else {
+		t.maxAge = DefaultMaxAge
+	}
+
+	//config max duration of lost tracks
+	maxLostDuration := DefaultMaxLostDuration
+	if trackerConfig.MaxLostDuration != nil {
+		if *trackerConfig.MaxLostDuration < 0 {
+			return errors.New("max_lost_duration_s is a duration given in seconds and should be above 0.")
+		}
+		maxLostDuration = *trackerConfig.MaxLostDuration
+	}
+	t.maxLostDuration = time.Duration(maxLostDuration * float64(time.Second))
 
 	//config trigger cool down
 	if trackerConfig.TriggerCoolDown != nil {
@@ -406,21 +702,316 @@ func (t *myTracker) Reconfigure(ctx context.Context, deps resource.Dependencies,
 		return errors.New("minimum thresholding confidence must be between 0.0 and 1.0")
 	}
 
+	//config low confidence threshold for the second association stage
+	t.useLowConfidence = trackerConfig.LowConfidence != nil
+	if t.useLowConfidence {
+		t.lowConfidenceThreshold = *trackerConfig.LowConfidence
+		if t.lowConfidenceThreshold < 0 || t.lowConfidenceThreshold >= t.minConfidence {
+			return errors.New("low_confidence_threshold must be between 0.0 and min_confidence")
+		}
+	}
+
+	//config cost function used for matching
+	t.costFunction, err = NewCostFunction(trackerConfig.CostFunction)
+	if err != nil {
+		return err
+	}
+	t.costFunctionName = trackerConfig.CostFunction
+	if t.costFunctionName == "" {
+		t.costFunctionName = DefaultCostFunction
+	}
+
+	//config match thresholds
+	if trackerConfig.MatchThreshold != nil {
+		t.matchThreshold = *trackerConfig.MatchThreshold
+	} else {
+		t.matchThreshold = DefaultMatchThreshold
+	}
+	if t.matchThreshold < 0 || t.matchThreshold > 1 {
+		return errors.New("match_threshold must be between 0.0 and 1.0")
+	}
+	t.classMatchThresholds = make(map[string]float64, len(trackerConfig.ClassMatchThreshold))
+	for class, threshold := range trackerConfig.ClassMatchThreshold {
+		if threshold < 0 || threshold > 1 {
+			return errors.Errorf("match threshold for class %v must be between 0.0 and 1.0", class)
+		}
+		t.classMatchThresholds[strings.ToLower(class)] = threshold
+	}
+
+	//config appearance matching
+	if trackerConfig.AppearanceWeight != nil {
+		t.appearanceWeight = *trackerConfig.AppearanceWeight
+	} else {
+		t.appearanceWeight = DefaultAppearanceWeight
+	}
+	if t.appearanceWeight < 0 || t.appearanceWeight > 1 {
+		return errors.New("appearance_weight must be between 0.0 and 1.0")
+	}
+	if trackerConfig.AppearanceRecovery != nil {
+		t.appearanceRecoveryThreshold = *trackerConfig.AppearanceRecovery
+	} else {
+		t.appearanceRecoveryThreshold = DefaultAppearanceRecoveryThreshold
+	}
+	if t.appearanceRecoveryThreshold < 0 || t.appearanceRecoveryThreshold > 1 {
+		return errors.New("appearance_recovery_threshold must be between 0.0 and 1.0")
+	}
+
+	//config re-identification weights
+	if trackerConfig.ReIDWeight != nil {
+		t.reidWeight = *trackerConfig.ReIDWeight
+	} else {
+		t.reidWeight = DefaultReIDWeight
+	}
+	if trackerConfig.ReIDModelName == "" {
+		t.reidWeight = 0
+	}
+	if t.reidWeight < 0 || t.reidWeight+t.appearanceWeight > 1 {
+		return errors.New("reid_weight must be between 0.0 and 1.0, and its sum with appearance_weight must not exceed 1.0")
+	}
+	if trackerConfig.ReIDRecovery != nil {
+		t.reidRecoveryThreshold = *trackerConfig.ReIDRecovery
+	} else {
+		t.reidRecoveryThreshold = DefaultReIDRecoveryThreshold
+	}
+	if t.reidRecoveryThreshold < 0 || t.reidRecoveryThreshold > 1 {
+		return errors.New("reid_recovery_threshold must be between 0.0 and 1.0")
+	}
+	if trackerConfig.ReIDConcurrency < 0 {
+		return errors.New("reid_concurrency cannot be less than 0")
+	}
+	t.reidConcurrency = DefaultReIDConcurrency
+	if trackerConfig.ReIDConcurrency != 0 {
+		t.reidConcurrency = trackerConfig.ReIDConcurrency
+	}
+	reidTimeout := DefaultReIDTimeout
+	if trackerConfig.ReIDTimeout != nil {
+		reidTimeout = *trackerConfig.ReIDTimeout
+	}
+	if reidTimeout < 0 {
+		return errors.New("reid_timeout_s cannot be less than 0")
+	}
+	t.reidTimeout = time.Duration(reidTimeout * float64(time.Second))
+
+	//config counting lines
+	lineNames := make(map[string]struct{}, len(trackerConfig.CountingLines))
+	for i := range trackerConfig.CountingLines {
+		l := &trackerConfig.CountingLines[i]
+		if err := l.validate(); err != nil {
+			return err
+		}
+		if _, ok := lineNames[l.Name]; ok {
+			return errors.Errorf("counting line names must be unique, got %v twice", l.Name)
+		}
+		lineNames[l.Name] = struct{}{}
+	}
+	if len(trackerConfig.CountingLines) > 0 && t.historySize < 2 {
+		return errors.New("history_size must be at least 2 to count the tracks crossing lines")
+	}
+	t.lineCounter = newLineCounter(trackerConfig.CountingLines)
+
+	//config zones
+	zoneNames := make(map[string]struct{}, len(trackerConfig.Zones))
+	for i := range trackerConfig.Zones {
+		z := &trackerConfig.Zones[i]
+		if err := z.validate(); err != nil {
+			return err
+		}
+		if _, ok := zoneNames[z.Name]; ok {
+			return errors.Errorf("zone names must be unique, got %v twice", z.Name)
+		}
+		zoneNames[z.Name] = struct{}{}
+	}
+	t.zoneMonitor = newZoneMonitor(trackerConfig.Zones)
+
+	//config regions of interest
+	for _, regions := range [][]Region{trackerConfig.IncludeRegions, trackerConfig.ExcludeRegions} {
+		for i := range regions {
+			if err := regions[i].Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	t.includeRegions = trackerConfig.IncludeRegions
+	t.excludeRegions = trackerConfig.ExcludeRegions
+
+	//config geometry constraints
+	t.geometryConstraints = make(map[string]GeometryConstraints, len(trackerConfig.GeometryFilters))
+	for class, constraints := range trackerConfig.GeometryFilters {
+		if err := constraints.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid geometry filter for class %v", class)
+		}
+		t.geometryConstraints[strings.ToLower(class)] = constraints
+	}
+
+	//config suppression of duplicate detections
+	t.duplicateFilter = nil
+	if trackerConfig.NMSThreshold != nil {
+		t.duplicateFilter, err = NewDuplicateFilter(trackerConfig.NMSMethod, *trackerConfig.NMSThreshold, trackerConfig.NMSClassAgnostic)
+		if err != nil {
+			return err
+		}
+	}
+
 	t.chosenLabels = trackerConfig.ChosenLabels
 	t.camName = trackerConfig.CameraName
 	t.cam, err = camera.FromDependencies(deps, trackerConfig.CameraName)
 	if err != nil {
 		return errors.Wrapf(err, "unable to get camera %v for object tracker", trackerConfig.CameraName)
 	}
-	t.detector, err = vision.FromDependencies(deps, trackerConfig.DetectorName)
+	detectorConfigs := trackerConfig.DetectorNames
+	if trackerConfig.DetectorName != "" {
+		detectorConfigs = []DetectorConfig{{Name: trackerConfig.DetectorName}}
+	}
+	for _, d := range detectorConfigs {
+		detector, err := vision.FromDependencies(deps, d.Name)
+		if err != nil {
+			return errors.Wrapf(err, "unable to get detector %v for object tracker", d.Name)
+		}
+		weight := 1.0
+		if d.Weight != nil {
+			weight = *d.Weight
+		}
+		if weight <= 0 {
+			return errors.Errorf("weight of detector %v must be above 0", d.Name)
+		}
+		t.detectors = append(t.detectors, ensembleMember{name: d.Name, detector: detector, weight: weight, labelMap: d.LabelMap})
+	}
+	if trackerConfig.EnsembleIOUThreshold != nil {
+		t.ensembleIOUThreshold = *trackerConfig.EnsembleIOUThreshold
+	} else {
+		t.ensembleIOUThreshold = DefaultEnsembleIOUThreshold
+	}
+	if t.ensembleIOUThreshold < 0 || t.ensembleIOUThreshold > 1 {
+		return errors.New("ensemble_iou_threshold must be between 0.0 and 1.0")
+	}
+
+	//config label aliases
+	t.labelAliases, err = newLabelAliases(trackerConfig.LabelAliases)
+	if err != nil {
+		return err
+	}
+
+	//config label format
+	labelFormat := DefaultLabelFormat
+	if trackerConfig.LabelFormat != "" {
+		labelFormat = trackerConfig.LabelFormat
+	}
+	t.labelFormat, err = parseLabelFormat(labelFormat)
+	if err != nil {
+		return err
+	}
+	switch trackerConfig.LabelMode {
+	case "":
+		t.labelMode = LabelModeTrack
+	case LabelModeTrack, LabelModeClass:
+		t.labelMode = trackerConfig.LabelMode
+	default:
+		return errors.Errorf("unknown label_mode %q, must be %q or %q", trackerConfig.LabelMode, LabelModeTrack, LabelModeClass)
+	}
+
+	//config counter persistence
+	counterStateFile := ""
+	if trackerConfig.PersistCounters {
+		counterStateFile, err = counterStatePath(trackerConfig.CounterStateFile, conf.ResourceName().Name)
+		if err != nil {
+			return err
+		}
+	}
+	if err := t.useCounterStateFile(counterStateFile, time.Now()); err != nil {
+		return err
+	}
+	t.counterResetTime = nil
+	if trackerConfig.CounterResetTime != "" {
+		t.counterResetTime, err = parseDailyTime(trackerConfig.CounterResetTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	//config classification policy
+	classificationWindow := DefaultClassificationWindow
+	if trackerConfig.ClassificationWindow != 0 {
+		classificationWindow = trackerConfig.ClassificationWindow
+	}
+	classificationHysteresis := DefaultClassificationHysteresis
+	if trackerConfig.ClassificationHysteresis != 0 {
+		classificationHysteresis = trackerConfig.ClassificationHysteresis
+	}
+	t.classificationVoter, err = newClassificationVoter(trackerConfig.ClassificationPolicy, classificationWindow, classificationHysteresis)
 	if err != nil {
-		return errors.Wrapf(err, "unable to get detector %v for object tracker", trackerConfig.DetectorName)
+		return err
+	}
+
+	//config classification transitions
+	if trackerConfig.ClassificationTransitions != nil {
+		t.classificationTransitions = trackerConfig.ClassificationTransitions
+	} else {
+		t.classificationTransitions = DefaultClassificationTransitions
+	}
+	for i := range t.classificationTransitions {
+		if err := t.classificationTransitions[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	//config classify policy
+	classifyEveryNFrames := DefaultClassifyEveryNFrames
+	if trackerConfig.ClassifyEveryNFrames != 0 {
+		classifyEveryNFrames = trackerConfig.ClassifyEveryNFrames
+	}
+	classifyIOUDelta := DefaultClassifyIOUDelta
+	if trackerConfig.ClassifyIOUDelta != nil {
+		classifyIOUDelta = *trackerConfig.ClassifyIOUDelta
+	}
+	t.classifyPolicy, err = newClassifyPolicy(trackerConfig.ClassifyPolicy, classifyEveryNFrames, classifyIOUDelta)
+	if err != nil {
+		return err
+	}
+	if t.classifyPolicy.classifiesOnce() && trackerConfig.ClassificationTransitions == nil {
+		// the default split never applies to tracks classified once
+		t.classificationTransitions = nil
+	}
+	if err := t.classifyPolicy.checkTransitions(t.classificationTransitions); err != nil {
+		return err
+	}
+
+	//config classifier calls
+	if trackerConfig.ClassifierConcurrency < 0 {
+		return errors.New("classifier_concurrency cannot be less than 0")
+	}
+	t.classifierConcurrency = DefaultClassifierConcurrency
+	if trackerConfig.ClassifierConcurrency != 0 {
+		t.classifierConcurrency = trackerConfig.ClassifierConcurrency
+	}
+	classifierTimeout := DefaultClassifierTimeout
+	if trackerConfig.ClassifierTimeout != nil {
+		classifierTimeout = *trackerConfig.ClassifierTimeout
+	}
+	if classifierTimeout < 0 {
+		return errors.New("classifier_timeout_s cannot be less than 0")
+	}
+	t.classifierTimeout = time.Duration(classifierTimeout * float64(time.Second))
+	t.classifierMosaic = trackerConfig.ClassifierMosaic
+	if err := t.useCropper(trackerConfig.ClassifierCrop); err != nil {
+		return err
 	}
 	if trackerConfig.PizzaClassifierName != "" {
 		t.pizzaClassifier, err = vision.FromDependencies(deps, trackerConfig.PizzaClassifierName)
 		if err != nil {
 			return errors.Wrapf(err, "unable to get pizzaClassifier %v for object tracker", trackerConfig.PizzaClassifierName)
 		}
+		if t.classifierMosaic {
+			if err := checkMosaicClassifier(ctx, t.pizzaClassifier); err != nil {
+				return err
+			}
+		}
+	}
+	t.reidModel = nil
+	if trackerConfig.ReIDModelName != "" {
+		t.reidModel, err = vision.FromDependencies(deps, trackerConfig.ReIDModelName)
+		if err != nil {
+			return errors.Wrapf(err, "unable to get re-identification model %v for object tracker", trackerConfig.ReIDModelName)
+		}
 	}
 
 	return nil
@@ -440,9 +1031,7 @@ func (t *myTracker) DetectionsFromCamera(
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
-		t.currDetections.mutex.RLock()
-		dets := getStableDetections(t.currDetections.detections)
-		t.currDetections.mutex.RUnlock()
+		dets, _ := t.stableDetections()
 		return dets, nil
 	}
 }
@@ -454,9 +1043,7 @@ func (t *myTracker) Detections(ctx context.Context, img image.Image, extra map[s
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
-		t.currDetections.mutex.RLock()
-		dets := getStableDetections(t.currDetections.detections)
-		t.currDetections.mutex.RUnlock()
+		dets, _ := t.stableDetections()
 		return dets, nil
 	}
 }
@@ -470,21 +1057,22 @@ func (t *myTracker) ClassificationsFromCamera(
 	if cameraName != t.camName {
 		return nil, errors.Errorf("Camera name given to method, %v is not the same as configured camera %v", cameraName, t.camName)
 	}
-	if newInstance := t.newInstance.Load(); newInstance {
-		return []classification.Classification{classification.NewClassification(1, NewObjectDetectedLabel)}, nil
-	} else {
-		return []classification.Classification{}, nil
-	}
+	return t.currentClassifications(), nil
 }
 
 func (t *myTracker) Classifications(ctx context.Context, img image.Image,
 	n int, extra map[string]interface{},
 ) (classification.Classifications, error) {
+	return t.currentClassifications(), nil
+}
+
+// currentClassifications returns the new object label while the trigger is on, followed by the line counts
+func (t *myTracker) currentClassifications() []classification.Classification {
+	classifications := []classification.Classification{}
 	if newInstance := t.newInstance.Load(); newInstance {
-		return []classification.Classification{classification.NewClassification(1, NewObjectDetectedLabel)}, nil
-	} else {
-		return []classification.Classification{}, nil
+		classifications = append(classifications, classification.NewClassification(1, NewObjectDetectedLabel))
 	}
+	return append(classifications, t.lineCounter.classifications()...)
 }
 
 func (t *myTracker) GetProperties(ctx context.Context, extra map[string]interface{}) (*vision.Properties, error) {
@@ -507,6 +1095,7 @@ func (t *myTracker) CaptureAllFromCamera(
 	var detections []objdet.Detection
 	var classifications []classification.Classification
 	var img image.Image
+	var out map[string]interface{}
 	select {
 	case <-t.cancelContext.Done():
 		return viscapture.VisCapture{}, t.cancelContext.Err()
@@ -520,24 +1109,24 @@ func (t *myTracker) CaptureAllFromCamera(
 			img = *t.currImg.Load()
 		}
 		if opt.ReturnDetections {
-			t.currDetections.mutex.RLock()
-			detections = getStableDetections(t.currDetections.detections)
-			t.currDetections.mutex.RUnlock()
+			var trackLabels []string
+			detections, trackLabels = t.stableDetections()
+			if trackLabels != nil {
+				out = map[string]interface{}{"track_labels": trackLabels}
+			}
 		}
 		if opt.ReturnClassifications {
-			if newInstance := t.newInstance.Load(); newInstance {
-				classifications = []classification.Classification{classification.NewClassification(1, NewObjectDetectedLabel)}
-			} else {
-				classifications = []classification.Classification{}
-			}
+			classifications = t.currentClassifications()
 		}
 	}
-	return viscapture.VisCapture{Image: img, Detections: detections, Classifications: classifications}, nil
+	return viscapture.VisCapture{Image: img, Detections: detections, Classifications: classifications, Extra: out}, nil
 }
 
 func (t *myTracker) Close(ctx context.Context) error {
 	t.cancelFunc()
 	t.activeBackgroundWorkers.Wait()
+	t.cropper.close()
+	t.flushCounters()
 	return nil
 }
 
@@ -546,37 +1135,76 @@ type benchmark struct {
 	Fastest      float64
 	Average      float64
 	NumberOfRuns int
+	Histogram    map[string]int64
+	// Stages are the timings of the detect, classify and match stages of the loop
+	Stages map[string]benchmark `json:",omitempty"`
+}
+
+type diagnostics struct {
+	CostFunction         string
+	MatchThreshold       float64
+	ClassMatchThresholds map[string]float64
+	RejectedMatches      int64
 }
 
-// DoCommand will return the slowest, fastest, and average time of the tracking module
+// DoCommand will return the slowest, fastest, and average time of the tracking module,
+// the matching diagnostics, the memory statistics, the line counts, the zone occupancy, and/or the logs of the tracked objects
 func (t *myTracker) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
 	// average, fastest, and slowest time (and n)
 	out := make(map[string]interface{})
 	if cmd["benchmark"] != nil {
-		tmin, tmax := 10*time.Second, 10*time.Nanosecond
-		n := int64(len(t.timeStats))
-		var sum time.Duration
-		for _, tt := range t.timeStats {
-			if tt < tmin {
-				tmin = tt
-			}
-			if tt > tmax {
-				tmax = tt
-			}
-			sum += tt
-		}
-		mean := time.Duration(int64(sum) / n)
-		out["benchmark"] = benchmark{
-			Slowest:      float64(tmax),
-			Fastest:      float64(tmin),
-			Average:      float64(mean),
-			NumberOfRuns: int(n),
+		bench := t.timeStats.benchmark()
+		bench.Stages = t.stageStats.benchmarks()
+		out["benchmark"] = bench
+	}
+	if cmd["memory"] != nil {
+		t.memStats.mutex.RLock()
+		out["memory"] = t.memStats.stats
+		t.memStats.mutex.RUnlock()
+	}
+	if cmd["diagnostics"] != nil {
+		out["diagnostics"] = diagnostics{
+			CostFunction:         t.costFunctionName,
+			MatchThreshold:       t.matchThreshold,
+			ClassMatchThresholds: t.classMatchThresholds,
+			RejectedMatches:      t.rejectedMatches.Load(),
 		}
 	}
 	if cmd["logs"] != nil {
-		t.allFreshObjects.mutex.RLock()
-		out["logs"] = t.allFreshObjects.objects
-		t.allFreshObjects.mutex.RUnlock()
+		out["logs"] = t.allFreshObjects.list()
+	}
+	if cmd["counts"] != nil {
+		out["counts"] = t.lineCounter.snapshot()
+	}
+	if cmd["zones"] != nil {
+		out["zones"] = t.zoneMonitor.status(time.Now())
+	}
+	if cmd["zone_events"] != nil {
+		out["zone_events"] = t.zoneMonitor.list()
+	}
+	if cmd["events"] != nil {
+		out["events"] = t.trackEvents.list()
+	}
+	if cmd["classification_events"] != nil {
+		out["classification_events"] = t.classificationEvents.list()
+	}
+	if label, ok := cmd["get_track"]; ok {
+		labelStr, ok := label.(string)
+		if !ok {
+			return nil, errors.New("get_track expects the label of a track")
+		}
+		info, err := t.getTrack(labelStr)
+		if err != nil {
+			return nil, err
+		}
+		out["track"] = info
+	}
+	if opts, ok := cmd["list_tracks"]; ok {
+		filter, err := parseTrackFilter(opts)
+		if err != nil {
+			return nil, err
+		}
+		out["tracks"] = t.listTracks(filter)
 	}
 	return out, nil
 }
@@ -584,6 +1212,8 @@ func (t *myTracker) DoCommand(ctx context.Context, cmd map[string]interface{}) (
 type tracksBuffer struct {
 	detections [][]*track
 	size       int
+	// maxTracks is the maximum number of lost tracks kept across all the frames, 0 for no limit
+	maxTracks int
 }
 
 // newTracksBuffer initializes a new fixed-length queue with the specified size.
@@ -593,18 +1223,23 @@ func newTracksBuffer(size int) *tracksBuffer {
 		size:       size,
 	}
 }
-func (b *tracksBuffer) AppendDets(newDets []*track) {
+
+// AppendDets adds the newly lost tracks to the buffer. When the buffer is full, the oldest lost
+// tracks are evicted and returned.
+func (b *tracksBuffer) AppendDets(newDets []*track) []*track {
+	var evicted []*track
 	if len(b.detections) == b.size {
+		evicted = b.detections[0]
 		b.detections = b.detections[1:]
 	}
 
 	//remove old dets to match new dets only on the most recent detections
 	for _, newDet := range newDets {
-		countLabel := strings.Join(strings.Split(newDet.Det.Label(), "_")[0:2], "_")
+		countLabel := getTrackingLabel(newDet)
 		for i := range b.detections {
 			dets := b.detections[i]
 			for idx, det := range dets {
-				oldCountLabel := strings.Join(strings.Split(det.Det.Label(), "_")[0:2], "_")
+				oldCountLabel := getTrackingLabel(det)
 				if countLabel == oldCountLabel {
 					b.detections[i] = append(dets[:idx], dets[idx+1:]...)
 					break
@@ -614,4 +1249,49 @@ func (b *tracksBuffer) AppendDets(newDets []*track) {
 	}
 
 	b.detections = append(b.detections, newDets)
+
+	// past maxTracks, the tracks lost the longest ago are evicted first
+	for b.maxTracks > 0 && b.count() > b.maxTracks {
+		for i, dets := range b.detections {
+			if len(dets) > 0 {
+				evicted = append(evicted, dets[0])
+				b.detections[i] = dets[1:]
+				break
+			}
+		}
+	}
+	return evicted
+}
+
+// count returns the number of lost tracks in the buffer
+func (b *tracksBuffer) count() int {
+	n := 0
+	for _, dets := range b.detections {
+		n += len(dets)
+	}
+	return n
+}
+
+// removeFunc removes the tracks for which remove returns true from the buffer, and returns them
+func (b *tracksBuffer) removeFunc(remove func(*track) bool) []*track {
+	var removed []*track
+	for i, dets := range b.detections {
+		kept := make([]*track, 0, len(dets))
+		for _, det := range dets {
+			if remove(det) {
+				removed = append(removed, det)
+			} else {
+				kept = append(kept, det)
+			}
+		}
+		b.detections[i] = kept
+	}
+	return removed
+}
+
+// appendLostTracks stores the newly lost tracks in the buffer, and deletes the tracks evicted from it
+func (t *myTracker) appendLostTracks(lostDetections []*track) {
+	for _, tr := range t.lostDetectionsBuffer.AppendDets(lostDetections) {
+		t.transition(tr, trackDeleted)
+	}
 }
@@ -2,10 +2,18 @@ package tracker
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	hg "github.com/charles-haynes/munkres"
 	"go.viam.com/rdk/components/camera"
@@ -15,6 +23,7 @@ import (
 	"go.viam.com/rdk/rimage"
 	"go.viam.com/rdk/services/vision"
 	"go.viam.com/rdk/testutils/inject"
+	rutils "go.viam.com/rdk/utils"
 	"go.viam.com/rdk/vision/classification"
 	objdet "go.viam.com/rdk/vision/objectdetection"
 	"go.viam.com/test"
@@ -237,7 +246,7 @@ func TestTracker(t *testing.T) {
 	filteredNew = newTracks(fd.fakeDetections(), TestPersistenceLimit) //get fish but somewhere else
 
 	// Store oldDetection and lost detections in allDetections
-	allDetections := fakeTracker.lastDetections
+	allDetections := append([]*track{}, fakeTracker.lastDetections...)
 	for _, dets := range fakeTracker.lostDetectionsBuffer.detections {
 		allDetections = append(allDetections, dets...)
 	}
@@ -281,7 +290,7 @@ func TestTracker(t *testing.T) {
 	checkLabel(t, filteredNew[0], LabelDet0)
 
 	// Store oldDetection and lost detections in allDetections
-	allDetections = fakeTracker.lastDetections
+	allDetections = append([]*track{}, fakeTracker.lastDetections...)
 	for _, dets := range fakeTracker.lostDetectionsBuffer.detections {
 		allDetections = append(allDetections, dets...)
 	}
@@ -364,3 +373,1583 @@ func TestTracker(t *testing.T) {
 	test.That(t, len(currDetections), test.ShouldEqual, 1)
 	checkLabel(t, currDetections[0], LabelDet0)
 }
+
+func TestKalmanFilter(t *testing.T) {
+	start := time.Now()
+	// a box moving right at 100 pixels per second, observed at 10Hz
+	box := func(sec float64) image.Rectangle {
+		x := int(100 * sec)
+		return image.Rect(x, 50, x+40, 90)
+	}
+	kf := newKalmanFilter(box(0), start)
+	for i := 1; i <= 10; i++ {
+		sec := float64(i) / 10
+		kf.update(box(sec), start.Add(time.Duration(sec*float64(time.Second))))
+	}
+
+	// prediction should follow the motion and keep the size
+	pred := kf.predict(start.Add(1100 * time.Millisecond))
+	expected := box(1.1)
+	test.That(t, math.Abs(float64(pred.Min.X-expected.Min.X)), test.ShouldBeLessThanOrEqualTo, 2)
+	test.That(t, math.Abs(float64(pred.Min.Y-expected.Min.Y)), test.ShouldBeLessThanOrEqualTo, 2)
+	test.That(t, math.Abs(float64(pred.Dx()-expected.Dx())), test.ShouldBeLessThanOrEqualTo, 2)
+	test.That(t, math.Abs(float64(pred.Dy()-expected.Dy())), test.ShouldBeLessThanOrEqualTo, 2)
+
+	// prediction scales with the elapsed time, not the number of frames
+	pred = kf.predict(start.Add(1500 * time.Millisecond))
+	expected = box(1.5)
+	test.That(t, math.Abs(float64(pred.Min.X-expected.Min.X)), test.ShouldBeLessThanOrEqualTo, 3)
+
+	// predicting does not modify the filter
+	test.That(t, kf.predict(start.Add(1100*time.Millisecond)), test.ShouldResemble, kf.predict(start.Add(1100*time.Millisecond)))
+	test.That(t, kf.box(), test.ShouldResemble, kf.predict(kf.lastUpdate))
+
+	// the deprecated two-frame prediction goes through the same motion model
+	next := PredictNextFrame(box(0), box(1))
+	test.That(t, next.Min.X, test.ShouldBeGreaterThan, box(1).Min.X)
+	test.That(t, math.Abs(float64(next.Dx()-box(1).Dx())), test.ShouldBeLessThanOrEqualTo, 2)
+	test.That(t, math.Abs(float64(next.Min.Y-box(1).Min.Y)), test.ShouldBeLessThanOrEqualTo, 2)
+
+	// new tracks take the time of the frame they were detected in, not the time they were built
+	captured := start.Add(-300 * time.Millisecond)
+	tr := newTracksSeenAt([]objdet.Detection{objdet.NewDetection(box(0), 0.9, "pizza")}, 1, captured)
+	test.That(t, tr[0].seenAt, test.ShouldEqual, captured)
+	test.That(t, tr[0].stateSince, test.ShouldEqual, captured)
+}
+
+func TestCostFunctions(t *testing.T) {
+	box := image.Rect(0, 0, 10, 10)
+	same := image.Rect(0, 0, 10, 10)
+	shifted := image.Rect(5, 0, 15, 10)
+	adjacent := image.Rect(12, 0, 22, 10)
+	apart := image.Rect(20, 0, 30, 10)
+	farApart := image.Rect(100, 0, 110, 10)
+	wide := image.Rect(0, 3, 10, 7)
+
+	// IOU
+	test.That(t, IOU(&box, &same), test.ShouldEqual, 1)
+	test.That(t, IOU(&box, &shifted), test.ShouldAlmostEqual, 50./150.)
+	test.That(t, IOU(&box, &apart), test.ShouldEqual, 0)
+
+	// GIOU
+	test.That(t, GIOU(&box, &same), test.ShouldAlmostEqual, 1)
+	test.That(t, GIOU(&box, &shifted), test.ShouldAlmostEqual, 50./150.)
+	test.That(t, GIOU(&box, &apart), test.ShouldAlmostEqual, -100./300.)
+	test.That(t, GIOU(&box, &farApart), test.ShouldBeLessThan, GIOU(&box, &apart))
+
+	// DIOU
+	test.That(t, DIOU(&box, &same), test.ShouldAlmostEqual, 1)
+	test.That(t, DIOU(&box, &shifted), test.ShouldAlmostEqual, 50./150.-25./325.)
+	test.That(t, DIOU(&box, &apart), test.ShouldAlmostEqual, -400./1000.)
+	test.That(t, DIOU(&box, &farApart), test.ShouldBeLessThan, DIOU(&box, &apart))
+
+	// CIOU only differs from DIOU when the aspect ratios differ
+	test.That(t, CIOU(&box, &same), test.ShouldAlmostEqual, 1)
+	test.That(t, CIOU(&box, &shifted), test.ShouldAlmostEqual, DIOU(&box, &shifted))
+	test.That(t, CIOU(&box, &wide), test.ShouldBeLessThan, DIOU(&box, &wide))
+
+	// CenterDistance
+	test.That(t, CenterDistance(&box, &same), test.ShouldEqual, 0)
+	test.That(t, CenterDistance(&box, &shifted), test.ShouldAlmostEqual, 5/math.Hypot(10, 10))
+	test.That(t, CenterDistance(&box, &farApart), test.ShouldBeGreaterThan, 1)
+
+	// Cost functions are between -1 and 0, 0 meaning no possible match
+	for _, name := range []string{IOUCostName, GIOUCostName, DIOUCostName, CIOUCostName, CenterDistanceCostName} {
+		cost, err := NewCostFunction(name)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cost.Cost(&box, &same), test.ShouldAlmostEqual, -1)
+		test.That(t, cost.Cost(&box, &shifted), test.ShouldBeLessThan, cost.Cost(&box, &apart))
+		test.That(t, cost.Cost(&box, &farApart), test.ShouldBeLessThanOrEqualTo, 0)
+	}
+	// boxes that don't overlap can only be matched by the other cost functions
+	iou, _ := NewCostFunction("")
+	test.That(t, iou.Cost(&box, &adjacent), test.ShouldEqual, 0)
+	centerDistance, _ := NewCostFunction(CenterDistanceCostName)
+	test.That(t, centerDistance.Cost(&box, &adjacent), test.ShouldBeLessThan, 0)
+	test.That(t, centerDistance.Cost(&box, &farApart), test.ShouldEqual, 0)
+
+	// boxes further apart than their size are never matched, even with the default match threshold
+	for _, name := range []string{GIOUCostName, DIOUCostName, CIOUCostName} {
+		cost, err := NewCostFunction(name)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cost.Cost(&box, &adjacent), test.ShouldBeLessThan, 0)
+		test.That(t, cost.Cost(&box, &farApart), test.ShouldEqual, 0)
+
+		fakeTracker := &myTracker{
+			logger:               logging.NewTestLogger(t),
+			classCounter:         make(map[string]int),
+			tracks:               make(map[string][]*track),
+			lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+			allFreshObjects:      allObjects{objects: []trackedObject{}},
+			costFunction:         cost,
+			matchThreshold:       DefaultMatchThreshold,
+		}
+		oldDets := []*track{fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(box, 1, LabelDet0), TestPersistenceLimit))}
+		newDets := newTracks([]objdet.Detection{objdet.NewDetection(farApart, 1, LabelDet0)}, TestPersistenceLimit)
+		matchMtx := fakeTracker.BuildMatchingMatrix(oldDets, newDets)
+		HA, err := hg.NewHungarianAlgorithm(matchMtx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, fakeTracker.GateMatches(HA.Execute(), matchMtx, oldDets), test.ShouldResemble, []int{-1})
+	}
+
+	_, err := NewCostFunction("manhattan")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestGateMatches(t *testing.T) {
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		matchThreshold:       0.3,
+		classMatchThresholds: map[string]float64{LabelDet1: 0.9},
+	}
+	cat := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit))
+	fish := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(50, 50, 60, 60), 1, LabelDet1), TestPersistenceLimit))
+	other := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(100, 100, 110, 110), 1, LabelDet0), TestPersistenceLimit))
+	oldDets := []*track{cat, fish, other}
+	newDets := newTracks([]objdet.Detection{
+		objdet.NewDetection(image.Rect(1, 0, 11, 10), 1, LabelDet0),       // strong overlap with the cat
+		objdet.NewDetection(image.Rect(52, 52, 62, 62), 1, LabelDet1),     // fish overlap below the fish threshold
+		objdet.NewDetection(image.Rect(200, 200, 210, 210), 1, LabelDet0), // no overlap with anything
+	}, TestPersistenceLimit)
+
+	matchMtx := fakeTracker.BuildMatchingMatrix(oldDets, newDets)
+	HA, err := hg.NewHungarianAlgorithm(matchMtx)
+	test.That(t, err, test.ShouldBeNil)
+	matches := fakeTracker.GateMatches(HA.Execute(), matchMtx, oldDets)
+	test.That(t, matches, test.ShouldResemble, []int{0, -1, -1})
+	// only the fish is rejected by its threshold, the other track was never a candidate
+	test.That(t, fakeTracker.rejectedMatches.Load(), test.ShouldEqual, 1)
+
+	// rejected detections start new tracks
+	updated, _, fresh := fakeTracker.RenameFromMatches(matches, matchMtx, oldDets, newDets)
+	test.That(t, len(updated), test.ShouldEqual, 1)
+	checkLabel(t, updated[0], LabelDet0+"_0")
+	test.That(t, len(fresh), test.ShouldEqual, 2)
+}
+
+func TestLowConfidenceAssociation(t *testing.T) {
+	dets := []objdet.Detection{
+		objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.9, LabelDet0),
+		objdet.NewDetection(image.Rect(50, 50, 60, 60), 0.3, LabelDet1),
+		objdet.NewDetection(image.Rect(100, 100, 110, 110), 0.3, LabelDet0),
+		objdet.NewDetection(image.Rect(150, 150, 160, 160), 0.05, LabelDet0),
+	}
+	high := FilterDetections(nil, dets, 0.5)
+	test.That(t, len(high), test.ShouldEqual, 1)
+	low := FilterLowConfidenceDetections(nil, dets, 0.5, 0.1)
+	test.That(t, len(low), test.ShouldEqual, 2)
+	// class names still have to be chosen
+	low = FilterLowConfidenceDetections(map[string]float64{LabelDet1: 0.2}, dets, 0.5, 0.1)
+	test.That(t, len(low), test.ShouldEqual, 1)
+	test.That(t, low[0].Label(), test.ShouldEqual, LabelDet1)
+	// a detection above conf but not above the threshold of its chosen label is a low confidence detection
+	chosen := map[string]float64{LabelDet0: 0.95, LabelDet1: 0.2}
+	low = FilterLowConfidenceDetections(chosen, dets, 0.5, 0.1)
+	test.That(t, len(low), test.ShouldEqual, 3)
+	test.That(t, low[0].Score(), test.ShouldEqual, 0.9)
+	test.That(t, len(FilterDetections(chosen, dets, 0.5)), test.ShouldEqual, 0)
+
+	// overlapping low confidence detections go through the duplicate filter too
+	nms, err := NewDuplicateFilter(NMSMethodSuppress, 0.5, false)
+	test.That(t, err, test.ShouldBeNil)
+	overlapping := append([]objdet.Detection{objdet.NewDetection(image.Rect(51, 51, 61, 61), 0.25, LabelDet1)}, dets...)
+	low = FilterLowConfidenceDetections(nil, overlapping, 0.5, 0.1)
+	test.That(t, len(low), test.ShouldEqual, 3)
+	test.That(t, len(nms(low)), test.ShouldEqual, 2)
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+	}
+	cat := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.9, LabelDet0), TestPersistenceLimit))
+	fish := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(50, 50, 60, 60), 0.9, LabelDet1), TestPersistenceLimit))
+	oldDets := []*track{cat, fish}
+
+	// first stage: only the cat is matched with a confident detection
+	highNew := newTracks(high, TestPersistenceLimit)
+	matchMtx := fakeTracker.BuildMatchingMatrix(oldDets, highNew)
+	HA, err := hg.NewHungarianAlgorithm(matchMtx)
+	test.That(t, err, test.ShouldBeNil)
+	matches := fakeTracker.GateMatches(HA.Execute(), matchMtx, oldDets)
+	test.That(t, matches, test.ShouldResemble, []int{0, -1})
+
+	// second stage: the fish is kept alive by a low confidence detection, the other one is dropped
+	lowNew := newTracks(FilterLowConfidenceDetections(nil, dets, 0.5, 0.1), TestPersistenceLimit)
+	updated, newlyStable, kept := fakeTracker.MatchLowConfidence(matches, oldDets, len(oldDets), lowNew)
+	test.That(t, len(updated)+len(newlyStable), test.ShouldEqual, 1)
+	test.That(t, kept, test.ShouldContainKey, 1)
+	test.That(t, len(kept), test.ShouldEqual, 1)
+	checkLabel(t, append(updated, newlyStable...)[0], LabelDet1+"_0")
+	test.That(t, fakeTracker.classCounter[LabelDet0], test.ShouldEqual, 0)
+}
+
+func TestAppearance(t *testing.T) {
+	// red square on the left, blue square on the right
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if x < 100 {
+				img.Set(x, y, color.RGBA{200, 30, 30, 255})
+			} else {
+				img.Set(x, y, color.RGBA{30, 30, 200, 255})
+			}
+		}
+	}
+	red1 := colorHistogram(img.SubImage(image.Rect(0, 0, 40, 40)))
+	red2 := colorHistogram(img.SubImage(image.Rect(50, 50, 90, 90)))
+	blue := colorHistogram(img.SubImage(image.Rect(120, 10, 160, 50)))
+	test.That(t, appearanceSimilarity(red1, red2), test.ShouldAlmostEqual, 1)
+	test.That(t, appearanceSimilarity(red1, blue), test.ShouldAlmostEqual, 0)
+
+	fakeTracker := &myTracker{
+		logger:                      logging.NewTestLogger(t),
+		classCounter:                make(map[string]int),
+		tracks:                      make(map[string][]*track),
+		lostDetectionsBuffer:        newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:             allObjects{objects: []trackedObject{}},
+		appearanceWeight:            0.5,
+		appearanceRecoveryThreshold: 0.8,
+	}
+	oldDets := describeTracks([]*track{
+		newTrack(objdet.NewDetection(image.Rect(0, 0, 40, 40), 1, LabelDet0), TestPersistenceLimit),
+		newTrack(objdet.NewDetection(image.Rect(120, 10, 160, 50), 1, LabelDet0), TestPersistenceLimit),
+	}, img)
+	for i, det := range oldDets {
+		oldDets[i] = fakeTracker.RenameFirstTime(det)
+	}
+	// the red track is lost, then reappears elsewhere without any overlap
+	fakeTracker.lostDetectionsBuffer.AppendDets(oldDets[:1])
+	oldDets[0].state = trackLost
+	newDets := describeTracks([]*track{
+		newTrack(objdet.NewDetection(image.Rect(50, 50, 90, 90), 1, LabelDet0), TestPersistenceLimit),
+	}, img)
+	matchMtx := fakeTracker.BuildMatchingMatrix(oldDets, newDets)
+	test.That(t, matchMtx[0][0], test.ShouldBeLessThan, 0)
+	test.That(t, matchMtx[1][0], test.ShouldEqual, 0)
+
+	// tracks that are not lost still need to overlap
+	oldDets[0].state = trackConfirmed
+	matchMtx = fakeTracker.BuildMatchingMatrix(oldDets, newDets)
+	test.That(t, matchMtx[0][0], test.ShouldEqual, 0)
+}
+
+func TestReID(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := Config{CameraName: "camera", DetectorName: "detector", ReIDModelName: "reid"}
+	deps, err := cfg.Validate("")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, deps, test.ShouldContain, "reid")
+
+	// the fake re-identification model tells crops apart by their width
+	reid := &inject.VisionService{
+		DoCommandFunc: func(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+			imgBytes, err := base64.StdEncoding.DecodeString(cmd[ReIDCommand].(string))
+			if err != nil {
+				return nil, err
+			}
+			img, err := rimage.DecodeImage(ctx, imgBytes, rutils.MimeTypeJPEG)
+			if err != nil {
+				return nil, err
+			}
+			if img.Bounds().Dx() == 40 {
+				return map[string]interface{}{ReIDEmbeddingKey: []interface{}{2.0, 0.0}}, nil
+			}
+			return map[string]interface{}{ReIDEmbeddingKey: []interface{}{0.0, 3.0}}, nil
+		},
+	}
+	fakeTracker := &myTracker{
+		logger:                logging.NewTestLogger(t),
+		classCounter:          make(map[string]int),
+		tracks:                make(map[string][]*track),
+		lostDetectionsBuffer:  newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:       allObjects{objects: []trackedObject{}},
+		reidModel:             reid,
+		reidWeight:            0.8,
+		reidRecoveryThreshold: 0.8,
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+
+	// two objects pass each other: geometry alone would swap their identities
+	a := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 40, 40), 1, LabelDet0), TestPersistenceLimit))
+	b := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(10, 0, 51, 40), 1, LabelDet0), TestPersistenceLimit))
+	a.embedding = []float64{1, 0}
+	b.embedding = []float64{0, 1}
+	oldDets := []*track{a, b}
+	newDets := fakeTracker.embedTracks(ctx, newTracks([]objdet.Detection{
+		objdet.NewDetection(image.Rect(10, 0, 50, 40), 1, LabelDet0),
+		objdet.NewDetection(image.Rect(0, 0, 41, 40), 1, LabelDet0),
+	}, TestPersistenceLimit), img)
+	test.That(t, newDets[0].embedding, test.ShouldResemble, []float64{1, 0})
+	test.That(t, newDets[1].embedding, test.ShouldResemble, []float64{0, 1})
+
+	matchMtx := fakeTracker.BuildMatchingMatrix(oldDets, newDets)
+	HA, err := hg.NewHungarianAlgorithm(matchMtx)
+	test.That(t, err, test.ShouldBeNil)
+	matches := fakeTracker.GateMatches(HA.Execute(), matchMtx, oldDets)
+	test.That(t, matches, test.ShouldResemble, []int{0, 1})
+
+	// the running average of the embedding stays a unit vector
+	updated, _ := fakeTracker.UpdateTrack(newDets[0], a)
+	test.That(t, updated.embedding[0], test.ShouldAlmostEqual, 1)
+	test.That(t, updated.embedding[1], test.ShouldAlmostEqual, 0)
+
+	// errors from the model leave the track without embedding
+	failing := &inject.VisionService{
+		DoCommandFunc: func(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+			return nil, errors.New("no model")
+		},
+	}
+	fakeTracker.reidModel = failing
+	failed := fakeTracker.embedTracks(ctx, newTracks([]objdet.Detection{objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0)}, TestPersistenceLimit), img)
+	test.That(t, failed[0].embedding, test.ShouldBeNil)
+
+	// the crops are embedded by a bounded pool of workers, and a call that hangs is abandoned after the timeout
+	var inFlight, maxInFlight atomic.Int64
+	hanging := &inject.VisionService{
+		DoCommandFunc: func(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	fakeTracker.reidModel = hanging
+	fakeTracker.reidConcurrency = 2
+	fakeTracker.reidTimeout = 20 * time.Millisecond
+	boxes := make([]objdet.Detection, 5)
+	for i := range boxes {
+		boxes[i] = objdet.NewDetection(image.Rect(i*10, 0, i*10+10, 10), 1, LabelDet0)
+	}
+	start := time.Now()
+	hung := fakeTracker.embedTracks(ctx, newTracks(boxes, TestPersistenceLimit), img)
+	test.That(t, time.Since(start), test.ShouldBeLessThan, time.Second)
+	test.That(t, maxInFlight.Load(), test.ShouldEqual, 2)
+	for _, tr := range hung {
+		test.That(t, tr.embedding, test.ShouldBeNil)
+	}
+}
+
+func TestTrackStateMachine(t *testing.T) {
+	test.That(t, canTransition(trackTentative, trackConfirmed), test.ShouldBeTrue)
+	test.That(t, canTransition(trackTentative, trackDeleted), test.ShouldBeTrue)
+	test.That(t, canTransition(trackTentative, trackLost), test.ShouldBeFalse)
+	test.That(t, canTransition(trackConfirmed, trackLost), test.ShouldBeTrue)
+	test.That(t, canTransition(trackConfirmed, trackDeleted), test.ShouldBeFalse)
+	test.That(t, canTransition(trackLost, trackConfirmed), test.ShouldBeTrue)
+	test.That(t, canTransition(trackLost, trackDeleted), test.ShouldBeTrue)
+	test.That(t, canTransition(trackDeleted, trackConfirmed), test.ShouldBeFalse)
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		lostDetectionsBuffer: newTracksBuffer(2),
+		maxAge:               2,
+	}
+	box := objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0)
+
+	// tentative until persistence is reached
+	tr := fakeTracker.RenameFirstTime(newTrack(box, TestPersistenceLimit))
+	test.That(t, tr.state, test.ShouldEqual, trackTentative)
+	tr, newlyStable := fakeTracker.UpdateTrack(newTrack(box, TestPersistenceLimit), tr)
+	test.That(t, newlyStable, test.ShouldBeFalse)
+	test.That(t, tr.state, test.ShouldEqual, trackTentative)
+	test.That(t, tr.stateAge, test.ShouldEqual, 1)
+	tr, newlyStable = fakeTracker.UpdateTrack(newTrack(box, TestPersistenceLimit), tr)
+	test.That(t, newlyStable, test.ShouldBeTrue)
+	test.That(t, tr.state, test.ShouldEqual, trackConfirmed)
+	test.That(t, tr.stateAge, test.ShouldEqual, 0)
+
+	// tentative tracks cannot be lost, only deleted
+	tentative := fakeTracker.RenameFirstTime(newTrack(box, TestPersistenceLimit))
+	test.That(t, fakeTracker.transition(tentative, trackLost), test.ShouldBeFalse)
+	test.That(t, tentative.state, test.ShouldEqual, trackTentative)
+
+	// lost, then found again
+	test.That(t, fakeTracker.transition(tr, trackLost), test.ShouldBeTrue)
+	fakeTracker.appendLostTracks([]*track{tr})
+	tr, newlyStable = fakeTracker.UpdateTrack(newTrack(box, TestPersistenceLimit), tr)
+	test.That(t, newlyStable, test.ShouldBeFalse)
+	test.That(t, tr.state, test.ShouldEqual, trackConfirmed)
+
+	// lost for more than max age
+	test.That(t, fakeTracker.transition(tr, trackLost), test.ShouldBeTrue)
+	fakeTracker.lostDetectionsBuffer = newTracksBuffer(2)
+	fakeTracker.appendLostTracks([]*track{tr})
+	fakeTracker.ageLostTracks()
+	fakeTracker.ageLostTracks()
+	test.That(t, tr.state, test.ShouldEqual, trackLost)
+	fakeTracker.ageLostTracks()
+	test.That(t, tr.state, test.ShouldEqual, trackDeleted)
+	test.That(t, len(fakeTracker.lostDetectionsBuffer.detections[0]), test.ShouldEqual, 0)
+
+	// evicted from a full buffer
+	other := fakeTracker.RenameFirstTime(newTrack(box, 0))
+	other, _ = fakeTracker.UpdateTrack(newTrack(box, 0), other)
+	test.That(t, fakeTracker.transition(other, trackLost), test.ShouldBeTrue)
+	fakeTracker.appendLostTracks([]*track{other})
+	fakeTracker.appendLostTracks(nil)
+	test.That(t, other.state, test.ShouldEqual, trackLost)
+	fakeTracker.appendLostTracks(nil)
+	test.That(t, other.state, test.ShouldEqual, trackDeleted)
+
+	events := fakeTracker.trackEvents.list()
+	test.That(t, len(events), test.ShouldEqual, 8)
+	test.That(t, events[0].From, test.ShouldEqual, "tentative")
+	test.That(t, events[0].To, test.ShouldEqual, "confirmed")
+	test.That(t, events[len(events)-1].To, test.ShouldEqual, "deleted")
+}
+
+func TestMaxLostDuration(t *testing.T) {
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		maxLostDuration:      time.Second,
+	}
+	box := objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0)
+	recent := fakeTracker.RenameFirstTime(newTrack(box, 0))
+	old := fakeTracker.RenameFirstTime(newTrack(box, 0))
+	for _, tr := range []*track{recent, old} {
+		test.That(t, fakeTracker.transition(tr, trackConfirmed), test.ShouldBeTrue)
+		test.That(t, fakeTracker.transition(tr, trackLost), test.ShouldBeTrue)
+	}
+	old.stateSince = time.Now().Add(-2 * time.Second)
+	fakeTracker.appendLostTracks([]*track{recent, old})
+
+	// expiry depends on the time spent lost, not on the number of frames
+	fakeTracker.ageLostTracks()
+	test.That(t, recent.state, test.ShouldEqual, trackLost)
+	test.That(t, old.state, test.ShouldEqual, trackDeleted)
+	test.That(t, len(fakeTracker.lostDetectionsBuffer.detections[0]), test.ShouldEqual, 1)
+}
+
+func TestMaxLostTracks(t *testing.T) {
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		lostDetectionsBuffer: newTracksBuffer(3),
+		maxLostDuration:      time.Hour,
+	}
+	fakeTracker.lostDetectionsBuffer.maxTracks = 2
+	box := objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0)
+	lost := make([]*track, 3)
+	for i := range lost {
+		lost[i] = fakeTracker.RenameFirstTime(newTrack(box, 0))
+		lost[i], _ = fakeTracker.UpdateTrack(newTrack(box, 0), lost[i])
+		test.That(t, fakeTracker.transition(lost[i], trackLost), test.ShouldBeTrue)
+	}
+
+	// past max_lost_tracks, the tracks lost the longest ago are deleted first
+	fakeTracker.appendLostTracks(lost[:2])
+	fakeTracker.appendLostTracks(lost[2:])
+	test.That(t, lost[0].state, test.ShouldEqual, trackDeleted)
+	test.That(t, lost[1].state, test.ShouldEqual, trackLost)
+	test.That(t, fakeTracker.lostDetectionsBuffer.count(), test.ShouldEqual, 2)
+
+	// buffer_size is still a number of frames, and a hard cap on top of max_lost_duration_s
+	fakeTracker.appendLostTracks(nil)
+	test.That(t, lost[1].state, test.ShouldEqual, trackLost)
+	fakeTracker.appendLostTracks(nil)
+	test.That(t, lost[1].state, test.ShouldEqual, trackDeleted)
+	test.That(t, lost[2].state, test.ShouldEqual, trackLost)
+	fakeTracker.appendLostTracks(nil)
+	test.That(t, lost[2].state, test.ShouldEqual, trackDeleted)
+	test.That(t, fakeTracker.lostDetectionsBuffer.count(), test.ShouldEqual, 0)
+}
+
+func TestBoundedMemory(t *testing.T) {
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		timeStats:            newLatencyHistogram(),
+		historySize:          3,
+	}
+	box := objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0)
+
+	// the history of a track is capped
+	tr := fakeTracker.RenameFirstTime(newTrack(box, TestPersistenceLimit))
+	for i := 1; i <= 5; i++ {
+		next := objdet.NewDetection(image.Rect(i, 0, 10+i, 10), 1, LabelDet0)
+		tr, _ = fakeTracker.UpdateTrack(newTrack(next, TestPersistenceLimit), tr)
+	}
+	history := fakeTracker.tracks[getTrackingLabel(tr)]
+	test.That(t, len(history), test.ShouldEqual, 3)
+	test.That(t, history[0].Det.BoundingBox().Min.X, test.ShouldEqual, 3)
+	test.That(t, history[2], test.ShouldEqual, tr)
+
+	// tracks that are neither current nor lost are forgotten
+	gone := fakeTracker.RenameFirstTime(newTrack(box, TestPersistenceLimit))
+	test.That(t, len(fakeTracker.tracks), test.ShouldEqual, 2)
+	fakeTracker.lastDetections = []*track{tr}
+	fakeTracker.collectGarbage()
+	test.That(t, fakeTracker.tracks, test.ShouldContainKey, getTrackingLabel(tr))
+	test.That(t, fakeTracker.tracks, test.ShouldNotContainKey, getTrackingLabel(gone))
+	test.That(t, fakeTracker.memStats.stats.Tracks, test.ShouldEqual, 1)
+	test.That(t, fakeTracker.memStats.stats.HistoryEntries, test.ShouldEqual, 3)
+
+	// the latency histogram has a fixed size
+	test.That(t, fakeTracker.timeStats.benchmark().NumberOfRuns, test.ShouldEqual, 0)
+	for i := 0; i < 1000; i++ {
+		fakeTracker.timeStats.add(time.Duration(i) * time.Millisecond)
+	}
+	bench := fakeTracker.timeStats.benchmark()
+	test.That(t, bench.NumberOfRuns, test.ShouldEqual, 1000)
+	test.That(t, bench.Fastest, test.ShouldEqual, 0)
+	test.That(t, bench.Slowest, test.ShouldEqual, float64(999*time.Millisecond))
+	test.That(t, bench.Histogram["<=1ms"], test.ShouldEqual, 2)
+	test.That(t, bench.Histogram[">5s"], test.ShouldEqual, 0)
+	test.That(t, len(fakeTracker.timeStats.buckets), test.ShouldEqual, len(latencyBucketBounds)+1)
+}
+
+func TestLogs(t *testing.T) {
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+	}
+	fakeTracker.allFreshObjects.resize(2)
+	for id := 0; id < 3; id++ {
+		fakeTracker.allFreshObjects.add(trackedObject{Label: "pizza", Id: id})
+	}
+	// the oldest log is replaced once the buffer is full
+	logs := fakeTracker.allFreshObjects.list()
+	test.That(t, len(logs), test.ShouldEqual, 2)
+	test.That(t, logs[0].Id, test.ShouldEqual, 1)
+	test.That(t, logs[1].Id, test.ShouldEqual, 2)
+	test.That(t, fakeTracker.allFreshObjects.index, test.ShouldNotContainKey, "pizza_0")
+
+	fakeTracker.updateLog("pizza_0", func(obj *trackedObject) { obj.Classification = FullPizzaLabel })
+	fakeTracker.updateLog("pizza_1", func(obj *trackedObject) { obj.Classification = PartialPizzaLabel })
+	logs = fakeTracker.allFreshObjects.list()
+	test.That(t, logs[0].Classification, test.ShouldEqual, PartialPizzaLabel)
+	test.That(t, logs[1].Classification, test.ShouldEqual, "")
+
+	// shrinking the buffer keeps the latest logs
+	fakeTracker.allFreshObjects.resize(1)
+	logs = fakeTracker.allFreshObjects.list()
+	test.That(t, len(logs), test.ShouldEqual, 1)
+	test.That(t, logs[0].Id, test.ShouldEqual, 2)
+	fakeTracker.updateLog("pizza_2", func(obj *trackedObject) { obj.Classification = FullPizzaLabel })
+	test.That(t, fakeTracker.allFreshObjects.list()[0].Classification, test.ShouldEqual, FullPizzaLabel)
+	count, size := fakeTracker.allFreshObjects.count()
+	test.That(t, count, test.ShouldEqual, 1)
+	test.That(t, size, test.ShouldEqual, 1)
+}
+
+func TestTrackQueries(t *testing.T) {
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+	}
+	pizza := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, "pizza"), 1))
+	pizza, _ = fakeTracker.UpdateTrack(newTrack(objdet.NewDetection(image.Rect(2, 0, 12, 10), 1, "pizza"), 1), pizza)
+	box := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(50, 50, 60, 60), 1, "box"), 2))
+
+	info, err := fakeTracker.getTrack(pizza.Det.Label())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, info.Label, test.ShouldEqual, pizza.Det.Label())
+	test.That(t, info.State, test.ShouldEqual, "confirmed")
+	test.That(t, len(info.History), test.ShouldEqual, 2)
+	test.That(t, info.History[1].XMin, test.ShouldEqual, 2)
+	test.That(t, info.FirstSeen.After(info.LastSeen), test.ShouldBeFalse)
+
+	info, err = fakeTracker.getTrack(getTrackingLabel(box))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, info.State, test.ShouldEqual, "tentative")
+
+	_, err = fakeTracker.getTrack("pizza_42")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// tracks are found by the label they are output with, whatever its format
+	formatted := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		labelFormat:          mustParseLabelFormat("{class}#{uuid}"),
+	}
+	named := formatted.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, "pizza"), 1))
+	test.That(t, named.Det.Label(), test.ShouldNotContainSubstring, "_")
+	info, err = formatted.getTrack(named.Det.Label())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, info.Label, test.ShouldEqual, named.Det.Label())
+	_, err = formatted.getTrack("pizza#unknown")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	filter, err := parseTrackFilter(map[string]interface{}{"state": "confirmed"})
+	test.That(t, err, test.ShouldBeNil)
+	infos := fakeTracker.listTracks(filter)
+	test.That(t, len(infos), test.ShouldEqual, 1)
+	test.That(t, infos[0].Label, test.ShouldEqual, pizza.Det.Label())
+
+	filter, err = parseTrackFilter(map[string]interface{}{"class": "Box", "include_history": false})
+	test.That(t, err, test.ShouldBeNil)
+	infos = fakeTracker.listTracks(filter)
+	test.That(t, len(infos), test.ShouldEqual, 1)
+	test.That(t, infos[0].History, test.ShouldBeNil)
+
+	filter, err = parseTrackFilter(true)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(fakeTracker.listTracks(filter)), test.ShouldEqual, 2)
+
+	_, err = parseTrackFilter(map[string]interface{}{"state": 3})
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestLineCounting(t *testing.T) {
+	lines := []CountingLine{
+		{Name: "oven", Start: []float64{50, 0}, End: []float64{50, 100}},
+		{Name: "door", Start: []float64{0.5, 0}, End: []float64{0.5, 1}, Normalized: true, Direction: DirectionIn},
+		{Name: "short", Start: []float64{50, 0}, End: []float64{50, 10}},
+	}
+	for _, l := range lines {
+		test.That(t, l.validate(), test.ShouldBeNil)
+	}
+	bad := CountingLine{Name: "bad", Start: []float64{0, 0}, End: []float64{1.5, 1}, Normalized: true}
+	test.That(t, bad.validate(), test.ShouldNotBeNil)
+	bad = CountingLine{Name: "bad", Start: []float64{0, 0}, End: []float64{10, 10}, Direction: "up"}
+	test.That(t, bad.validate(), test.ShouldNotBeNil)
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		lineCounter:          newLineCounter(lines),
+	}
+	bounds := image.Rect(0, 0, 100, 100)
+	move := func(tr *track, x int) *track {
+		det := objdet.NewDetection(image.Rect(x-5, 45, x+5, 55), 1, "pizza")
+		tr, _ = fakeTracker.UpdateTrack(newTrack(det, 1), tr)
+		fakeTracker.countCrossings([]*track{tr}, bounds)
+		return tr
+	}
+
+	// a tentative track is not counted
+	tentative := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(35, 45, 45, 55), 1, "box"), 5))
+	tentative, _ = fakeTracker.UpdateTrack(newTrack(objdet.NewDetection(image.Rect(55, 45, 65, 55), 1, "box"), 5), tentative)
+	fakeTracker.countCrossings([]*track{tentative}, bounds)
+	test.That(t, fakeTracker.lineCounter.snapshot()["oven"].Out, test.ShouldEqual, 0)
+
+	tr := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(35, 45, 45, 55), 1, "pizza"), 1))
+	// moving from left to right of the image crosses the downward lines from their right to their left
+	tr = move(tr, 60)
+	tr = move(tr, 70)
+	tr = move(tr, 40)
+	counts := fakeTracker.lineCounter.snapshot()
+	test.That(t, counts["oven"].Out, test.ShouldEqual, 1)
+	test.That(t, counts["oven"].In, test.ShouldEqual, 1)
+	test.That(t, counts["oven"].InByClass["pizza"], test.ShouldEqual, 1)
+	test.That(t, counts["door"].Out, test.ShouldEqual, 0)
+	test.That(t, counts["door"].In, test.ShouldEqual, 1)
+	test.That(t, counts["short"].In+counts["short"].Out, test.ShouldEqual, 0)
+
+	labels := []string{}
+	for _, c := range fakeTracker.lineCounter.classifications() {
+		labels = append(labels, c.Label())
+	}
+	test.That(t, labels, test.ShouldResemble, []string{
+		"line_oven_in:1", "line_oven_out:1", "line_door_in:1", "line_short_in:0", "line_short_out:0",
+	})
+	move(tr, 60)
+	test.That(t, fakeTracker.lineCounter.snapshot()["oven"].OutByClass["pizza"], test.ShouldEqual, 2)
+}
+
+func TestZones(t *testing.T) {
+	shelf := Zone{Name: "shelf", Points: [][]float64{{0, 0}, {0.5, 0}, {0.5, 0.5}, {0, 0.5}}, Normalized: true}
+	test.That(t, shelf.validate(), test.ShouldBeNil)
+	bad := Zone{Name: "bad", Points: [][]float64{{0, 0}, {1, 1}}}
+	test.That(t, bad.validate(), test.ShouldNotBeNil)
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		zoneMonitor:          newZoneMonitor([]Zone{shelf}),
+	}
+	bounds := image.Rect(0, 0, 100, 100)
+	start := time.Now()
+	move := func(tr *track, x, y int, ts time.Time) *track {
+		det := objdet.NewDetection(image.Rect(x-5, y-5, x+5, y+5), 1, "pizza")
+		tr, _ = fakeTracker.UpdateTrack(newTrack(det, 1), tr)
+		tr.seenAt = ts
+		fakeTracker.updateZones([]*track{tr}, bounds)
+		return tr
+	}
+
+	tr := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(15, 15, 25, 25), 1, "pizza"), 1))
+	fakeTracker.updateZones([]*track{tr}, bounds)
+	// the track is tentative, it is not in the zone yet
+	test.That(t, fakeTracker.zoneMonitor.status(start)["shelf"].Occupancy, test.ShouldEqual, 0)
+	fakeTracker.allFreshObjects.add(newTrackedObject(tr))
+
+	tr = move(tr, 25, 25, start)
+	status := fakeTracker.zoneMonitor.status(start.Add(time.Second))["shelf"]
+	test.That(t, status.Occupancy, test.ShouldEqual, 1)
+	test.That(t, status.OccupancyByClass["pizza"], test.ShouldEqual, 1)
+	test.That(t, status.DwellS[tr.Det.Label()], test.ShouldAlmostEqual, 1)
+
+	tr = move(tr, 30, 30, start.Add(2*time.Second))
+	tr = move(tr, 80, 80, start.Add(3*time.Second))
+	test.That(t, fakeTracker.zoneMonitor.status(start)["shelf"].Occupancy, test.ShouldEqual, 0)
+	events := fakeTracker.zoneMonitor.list()
+	test.That(t, len(events), test.ShouldEqual, 2)
+	test.That(t, events[0].Type, test.ShouldEqual, ZoneEnter)
+	test.That(t, events[1].Type, test.ShouldEqual, ZoneExit)
+	test.That(t, events[1].DwellS, test.ShouldAlmostEqual, 3)
+	test.That(t, fakeTracker.allFreshObjects.list()[0].ZoneDwellS["shelf"], test.ShouldAlmostEqual, 3)
+
+	// a deleted track leaves the zones it was in, as of the last time it was seen
+	tr = move(tr, 20, 20, start.Add(4*time.Second))
+	test.That(t, fakeTracker.transition(tr, trackLost), test.ShouldBeTrue)
+	test.That(t, fakeTracker.transition(tr, trackDeleted), test.ShouldBeTrue)
+	test.That(t, fakeTracker.zoneMonitor.status(start)["shelf"].Occupancy, test.ShouldEqual, 0)
+	test.That(t, len(fakeTracker.zoneMonitor.list()), test.ShouldEqual, 4)
+	test.That(t, fakeTracker.allFreshObjects.list()[0].ZoneDwellS["shelf"], test.ShouldAlmostEqual, 3)
+}
+
+func TestRegionFilter(t *testing.T) {
+	anyOverlap := 0.0
+	include := []Region{{Name: "counter", Rectangle: []float64{0, 0, 0.6, 1}, Normalized: true}}
+	exclude := []Region{{Name: "tv", Points: [][]float64{{0, 0}, {20, 0}, {20, 20}, {0, 20}}, Overlap: &anyOverlap}}
+	for _, r := range append(include, exclude...) {
+		test.That(t, r.Validate(), test.ShouldBeNil)
+	}
+	bad := Region{Rectangle: []float64{0, 0, 10, 10}, Points: [][]float64{{0, 0}, {1, 0}, {1, 1}}}
+	test.That(t, bad.Validate(), test.ShouldNotBeNil)
+	bad = Region{Rectangle: []float64{10, 0, 0, 10}}
+	test.That(t, bad.Validate(), test.ShouldNotBeNil)
+
+	triangle := []point{{0, 0}, {10, 0}, {0, 10}}
+	bb := image.Rect(0, 0, 10, 10)
+	test.That(t, overlapFraction(&bb, triangle), test.ShouldAlmostEqual, 0.5)
+
+	dets := []objdet.Detection{
+		objdet.NewDetection(image.Rect(30, 30, 40, 40), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(55, 50, 65, 60), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(58, 50, 68, 60), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(15, 15, 25, 25), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(80, 80, 90, 90), 0.9, "pizza"),
+	}
+	bounds := image.Rect(0, 0, 100, 100)
+	filtered := NewRegionFilter(include, exclude, bounds)(dets)
+	test.That(t, len(filtered), test.ShouldEqual, 2)
+	test.That(t, filtered[0], test.ShouldEqual, dets[0])
+	test.That(t, filtered[1], test.ShouldEqual, dets[1])
+
+	test.That(t, len(NewRegionFilter(nil, exclude, bounds)(dets)), test.ShouldEqual, 4)
+	test.That(t, len(NewRegionFilter(nil, nil, bounds)(dets)), test.ShouldEqual, 5)
+}
+
+func TestGeometryFilters(t *testing.T) {
+	constraints := map[string]GeometryConstraints{
+		"pizza":    {MaxArea: 0.5, MinAspect: 0.2, DropBorderTouching: true},
+		AllClasses: {MinArea: 0.02, MaxArea: 0.9},
+	}
+	for _, c := range constraints {
+		test.That(t, c.Validate(), test.ShouldBeNil)
+	}
+	bad := GeometryConstraints{MinArea: 0.5, MaxArea: 0.1}
+	test.That(t, bad.Validate(), test.ShouldNotBeNil)
+	bad = GeometryConstraints{MaxArea: 2}
+	test.That(t, bad.Validate(), test.ShouldNotBeNil)
+
+	dets := []objdet.Detection{
+		objdet.NewDetection(image.Rect(10, 10, 30, 30), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(0, 0, 100, 100), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(40, 40, 41, 60), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(0, 40, 20, 60), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(0, 0, 100, 100), 0.9, "box"),
+		objdet.NewDetection(image.Rect(1, 1, 90, 90), 0.9, "box"),
+		objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.9, "cat"),
+		objdet.NewDetection(image.Rect(50, 50, 55, 55), 0.9, "Pizza"),
+	}
+	bounds := image.Rect(0, 0, 100, 100)
+	filtered := NewGeometryFilter(constraints, bounds)(dets)
+	test.That(t, filtered, test.ShouldResemble, []objdet.Detection{dets[0], dets[5], dets[7]})
+
+	// each filter can be used on its own and composed with the other postprocessors
+	test.That(t, len(NewAreaFilter(0, 0.5, bounds)(dets)), test.ShouldEqual, 5)
+	test.That(t, len(NewAspectRatioFilter(0.2, 0)(dets)), test.ShouldEqual, 7)
+	test.That(t, len(NewBorderFilter(bounds)(dets)), test.ShouldEqual, 4)
+	composed := ComposeFilters(NewAdvancedFilter(map[string]float64{"pizza": 0.5}), objdet.NewScoreFilter(0.5), NewBorderFilter(bounds))
+	test.That(t, len(composed(dets)), test.ShouldEqual, 3)
+	test.That(t, len(NewClassFilter("box", NewBorderFilter(bounds))(dets)), test.ShouldEqual, 7)
+}
+
+func TestDuplicateFilters(t *testing.T) {
+	dets := []objdet.Detection{
+		objdet.NewDetection(image.Rect(50, 50, 60, 60), 0.6, "pizza"),
+		objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.9, "pizza"),
+		objdet.NewDetection(image.Rect(2, 0, 12, 10), 0.8, "pizza"),
+		objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.7, "box"),
+	}
+	nms := NewNMSFilter(0.5, false)(dets)
+	test.That(t, nms, test.ShouldResemble, []objdet.Detection{dets[1], dets[3], dets[0]})
+	nms = NewNMSFilter(0.5, true)(dets)
+	test.That(t, nms, test.ShouldResemble, []objdet.Detection{dets[1], dets[0]})
+	// the boxes overlap too little to be suppressed with a high threshold
+	test.That(t, len(NewNMSFilter(0.7, false)(dets)), test.ShouldEqual, 4)
+
+	wbf := NewWeightedBoxFusion(0.5, false)(dets)
+	test.That(t, len(wbf), test.ShouldEqual, 3)
+	test.That(t, *wbf[0].BoundingBox(), test.ShouldResemble, image.Rect(1, 0, 11, 10))
+	test.That(t, wbf[0].Score(), test.ShouldAlmostEqual, 0.85)
+	test.That(t, wbf[0].Label(), test.ShouldEqual, "pizza")
+	test.That(t, wbf[1], test.ShouldEqual, dets[3])
+	test.That(t, wbf[2], test.ShouldEqual, dets[0])
+
+	_, err := NewDuplicateFilter("soft", 0.5, false)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = NewDuplicateFilter(NMSMethodWBF, 1.5, false)
+	test.That(t, err, test.ShouldNotBeNil)
+	filter, err := NewDuplicateFilter("", 0.5, false)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(filter(dets)), test.ShouldEqual, 3)
+}
+
+func TestDetectorEnsemble(t *testing.T) {
+	detectorFunc := func(dets []objdet.Detection, err error) *inject.VisionService {
+		return &inject.VisionService{
+			DetectionsFunc: func(ctx context.Context, img image.Image, extra map[string]interface{}) ([]objdet.Detection, error) {
+				return dets, err
+			},
+		}
+	}
+	general := detectorFunc([]objdet.Detection{
+		objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.8, "food"),
+		objdet.NewDetection(image.Rect(50, 50, 60, 60), 0.9, "person"),
+	}, nil)
+	specialized := detectorFunc([]objdet.Detection{objdet.NewDetection(image.Rect(2, 0, 12, 10), 0.9, "pizza")}, nil)
+	broken := detectorFunc(nil, errors.New("broken"))
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		detectors: []ensembleMember{
+			{name: "general", detector: general, weight: 1, labelMap: map[string]string{"food": "pizza"}},
+			{name: "pizza", detector: specialized, weight: 2},
+			{name: "broken", detector: broken, weight: 1},
+		},
+		ensembleIOUThreshold: DefaultEnsembleIOUThreshold,
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	dets, err := fakeTracker.detect(context.Background(), img)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(dets), test.ShouldEqual, 2)
+	test.That(t, dets[0].Label(), test.ShouldEqual, "pizza")
+	test.That(t, *dets[0].BoundingBox(), test.ShouldResemble, image.Rect(1, 0, 11, 10))
+	test.That(t, dets[0].Score(), test.ShouldAlmostEqual, (2*0.9+0.8)/3)
+	test.That(t, dets[1].Label(), test.ShouldEqual, "person")
+	test.That(t, dets[1].Score(), test.ShouldAlmostEqual, 0.3)
+
+	// a single detector is used as is, apart from its label map
+	fakeTracker.detectors = fakeTracker.detectors[:1]
+	dets, err = fakeTracker.detect(context.Background(), img)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(dets), test.ShouldEqual, 2)
+	test.That(t, dets[0].Label(), test.ShouldEqual, "pizza")
+	test.That(t, dets[0].Score(), test.ShouldEqual, 0.8)
+
+	fakeTracker.detectors = []ensembleMember{{name: "a", detector: broken, weight: 1}, {name: "b", detector: broken, weight: 1}}
+	_, err = fakeTracker.detect(context.Background(), img)
+	test.That(t, err, test.ShouldNotBeNil)
+
+	cfg := Config{CameraName: "camera", DetectorNames: []DetectorConfig{{Name: "general"}, {Name: "pizza"}}}
+	deps, err := cfg.Validate("")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, deps, test.ShouldResemble, []string{"camera", "general", "pizza"})
+	cfg.DetectorName = "detector"
+	_, err = cfg.Validate("")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestLabelAliases(t *testing.T) {
+	_, err := newLabelAliases(map[string]string{"pizza": "pizza_pie"})
+	test.That(t, err, test.ShouldNotBeNil)
+	aliases, err := newLabelAliases(map[string]string{"Pizza_Pie": "pizza", "food:pizza": "Pizza"})
+	test.That(t, err, test.ShouldBeNil)
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		labelAliases:         aliases,
+	}
+	dets := fakeTracker.aliasLabels([]objdet.Detection{
+		objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.9, "pizza_pie"),
+		objdet.NewDetection(image.Rect(20, 20, 30, 30), 0.9, "FOOD:PIZZA"),
+		objdet.NewDetection(image.Rect(40, 40, 50, 50), 0.9, "person"),
+	})
+	test.That(t, dets[0].Label(), test.ShouldEqual, "pizza")
+	test.That(t, dets[1].Label(), test.ShouldEqual, "pizza")
+	test.That(t, dets[2].Label(), test.ShouldEqual, "person")
+
+	// the aliased detections are filtered and counted as their canonical class
+	filtered := FilterDetections(map[string]float64{"pizza": 0.5}, dets, 0.2)
+	test.That(t, len(filtered), test.ShouldEqual, 2)
+	tracks := newTracks(filtered, TestPersistenceLimit)
+	first := fakeTracker.RenameFirstTime(tracks[0])
+	second := fakeTracker.RenameFirstTime(tracks[1])
+	test.That(t, getTrackingLabel(first), test.ShouldEqual, "pizza_0")
+	test.That(t, getTrackingLabel(second), test.ShouldEqual, "pizza_1")
+	test.That(t, first.rawLabel, test.ShouldEqual, "pizza_pie")
+	test.That(t, second.rawLabel, test.ShouldEqual, "FOOD:PIZZA")
+	test.That(t, newTrack(dets[2], TestPersistenceLimit).rawLabel, test.ShouldEqual, "")
+
+	// the fused detections of an ensemble keep the raw label of their highest scoring member
+	detectorFunc := func(dets ...objdet.Detection) *inject.VisionService {
+		return &inject.VisionService{
+			DetectionsFunc: func(ctx context.Context, img image.Image, extra map[string]interface{}) ([]objdet.Detection, error) {
+				return dets, nil
+			},
+		}
+	}
+	fakeTracker.detectors = []ensembleMember{
+		{name: "general", detector: detectorFunc(objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.8, "FOOD:PIZZA")), weight: 1},
+		{name: "pizza", detector: detectorFunc(objdet.NewDetection(image.Rect(2, 0, 12, 10), 0.9, "pizza_pie")), weight: 2},
+	}
+	fakeTracker.ensembleIOUThreshold = DefaultEnsembleIOUThreshold
+	fused, err := fakeTracker.detect(context.Background(), image.NewRGBA(image.Rect(0, 0, 100, 100)))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(fused), test.ShouldEqual, 1)
+	test.That(t, fused[0].Label(), test.ShouldEqual, "pizza")
+	test.That(t, newTrack(fused[0], TestPersistenceLimit).rawLabel, test.ShouldEqual, "pizza_pie")
+
+	// and so do the detections merged by weighted box fusion
+	merged := NewWeightedBoxFusion(0.5, false)([]objdet.Detection{dets[0], fused[0]})
+	test.That(t, len(merged), test.ShouldEqual, 1)
+	test.That(t, newTrack(merged[0], TestPersistenceLimit).rawLabel, test.ShouldEqual, "pizza_pie")
+}
+
+func TestTrackMetadata(t *testing.T) {
+	named := &track{class: "pizza", id: 3, namedAt: time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)}
+	test.That(t, defaultLabelFormat.label(named), test.ShouldEqual, "pizza_3_20240305_140709")
+	named.classification = FullPizzaLabel
+	test.That(t, defaultLabelFormat.label(named), test.ShouldEqual, "pizza_3_20240305_140709_full")
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+	}
+	tr := newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.9, "Pizza"), TestPersistenceLimit)
+	tr = fakeTracker.RenameFirstTime(tr)
+	test.That(t, tr.class, test.ShouldEqual, "pizza")
+	test.That(t, tr.id, test.ShouldEqual, 0)
+	test.That(t, getTrackingLabel(tr), test.ShouldEqual, "pizza_0")
+
+	// classifier labels with underscores are kept whole
+	tr.detClassification = classification.NewClassification(0.9, "half_eaten")
+	tr = tr.addClassificationToLabel("half_eaten", nil)
+	test.That(t, tr.Det.Label(), test.ShouldEqual, "pizza_0_"+tr.namedAt.Format(timestampFormat)+"_half_eaten")
+	test.That(t, getTrackingLabel(tr), test.ShouldEqual, "pizza_0")
+	obj := newTrackedObject(tr)
+	test.That(t, obj.FullLabel, test.ShouldEqual, tr.Det.Label())
+	test.That(t, obj.Label, test.ShouldEqual, "pizza")
+	test.That(t, obj.Id, test.ShouldEqual, 0)
+	test.That(t, obj.Time, test.ShouldEqual, tr.namedAt.Format(timestampFormat))
+	test.That(t, obj.Classification, test.ShouldEqual, "half_eaten")
+}
+
+func TestLabelFormat(t *testing.T) {
+	for _, template := range []string{"{class}", "{class}_{id", "{class}}_{id}", "{class}_{count}", "{id:02}", "{{id}}"} {
+		_, err := parseLabelFormat(template)
+		test.That(t, err, test.ShouldNotBeNil)
+	}
+	tr := &track{class: "pizza", id: 7, namedAt: time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC), uuid: "1234-abcd"}
+	format, err := parseLabelFormat("{class}#{uuid}")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, format.label(tr), test.ShouldEqual, "pizza#1234-abcd")
+	format, err = parseLabelFormat("{class}-{id}@{first_seen:2006-01-02}/{classification}")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, format.label(tr), test.ShouldEqual, "pizza-7@2024-03-05")
+	tr.classification = FullPizzaLabel
+	test.That(t, format.label(tr), test.ShouldEqual, "pizza-7@2024-03-05/full")
+
+	// new tracks are named with the template of the tracker
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		labelFormat:          mustParseLabelFormat("{class}#{uuid}"),
+		labelMode:            LabelModeClass,
+	}
+	first := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.9, LabelDet0), TestPersistenceLimit))
+	second := fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(20, 20, 30, 30), 0.9, LabelDet0), TestPersistenceLimit))
+	test.That(t, first.Det.Label(), test.ShouldStartWith, LabelDet0+"#")
+	test.That(t, first.Det.Label(), test.ShouldNotEqual, second.Det.Label())
+	test.That(t, getTrackingLabel(second), test.ShouldEqual, LabelDet0+"_1")
+
+	// in class mode, the detections are labelled with their class and the track labels are returned separately
+	first.state = trackConfirmed
+	fakeTracker.currDetections.detections = []*track{first, second}
+	dets, labels := fakeTracker.stableDetections()
+	test.That(t, len(dets), test.ShouldEqual, 1)
+	test.That(t, dets[0].Label(), test.ShouldEqual, LabelDet0)
+	test.That(t, labels, test.ShouldResemble, []string{first.Det.Label()})
+
+	// an aliased track keeps the label given by the detector
+	fakeTracker.labelAliases = map[string]string{"kitty": LabelDet0}
+	aliased := fakeTracker.RenameFirstTime(newTrack(
+		fakeTracker.aliasLabels([]objdet.Detection{objdet.NewDetection(image.Rect(40, 40, 50, 50), 0.9, "Kitty")})[0], TestPersistenceLimit))
+	aliased.state = trackConfirmed
+	fakeTracker.currDetections.detections = []*track{first, aliased}
+	dets, labels = fakeTracker.stableDetections()
+	test.That(t, len(dets), test.ShouldEqual, 2)
+	test.That(t, dets[0].Label(), test.ShouldEqual, LabelDet0)
+	test.That(t, dets[1].Label(), test.ShouldEqual, "Kitty")
+	test.That(t, labels, test.ShouldResemble, []string{first.Det.Label(), aliased.Det.Label()})
+	test.That(t, aliased.Det.Label(), test.ShouldStartWith, LabelDet0+"#")
+}
+
+func TestPersistentCounters(t *testing.T) {
+	dir := t.TempDir()
+	newFakeTracker := func() *myTracker {
+		return &myTracker{
+			logger:               logging.NewTestLogger(t),
+			classCounter:         make(map[string]int),
+			tracks:               make(map[string][]*track),
+			lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+			allFreshObjects:      allObjects{objects: []trackedObject{}},
+			counterStateFile:     filepath.Join(dir, "counters.json"),
+			counterResetTime:     &dailyTime{hour: 4},
+		}
+	}
+	morning := time.Date(2024, 3, 5, 9, 0, 0, 0, time.Local)
+
+	fakeTracker := newFakeTracker()
+	test.That(t, fakeTracker.loadCounters(morning), test.ShouldBeNil)
+	for i := 0; i < 3; i++ {
+		fakeTracker.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit))
+	}
+	fakeTracker.flushCounters()
+	entries, err := filepath.Glob(filepath.Join(dir, "*"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, entries, test.ShouldResemble, []string{filepath.Join(dir, "counters.json")})
+
+	// after a restart on the same day, the numbers continue
+	restarted := newFakeTracker()
+	test.That(t, restarted.loadCounters(morning.Add(time.Hour)), test.ShouldBeNil)
+	tr := restarted.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit))
+	test.That(t, getTrackingLabel(tr), test.ShouldEqual, LabelDet0+"_3")
+
+	restarted.flushCounters()
+
+	// while tracking, a new track number is always written, other changes at most once per counterSaveInterval,
+	// and the counters are written when the tracker closes
+	closing := newFakeTracker()
+	test.That(t, closing.loadCounters(morning.Add(time.Hour)), test.ShouldBeNil)
+	closing.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit))
+	test.That(t, closing.encodeCounters(morning.Add(time.Hour), false), test.ShouldNotBeNil)
+	closing.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit))
+	closing.saveCounters(closing.encodeCounters(morning.Add(time.Hour+counterSaveInterval/2), false))
+	closing.countersDirty = true
+	test.That(t, closing.encodeCounters(morning.Add(time.Hour+counterSaveInterval/2), false), test.ShouldBeNil)
+	closing.cancelFunc = func() {}
+	test.That(t, closing.Close(context.Background()), test.ShouldBeNil)
+	test.That(t, closing.countersDirty, test.ShouldBeFalse)
+	closed := newFakeTracker()
+	test.That(t, closed.loadCounters(morning.Add(time.Hour)), test.ShouldBeNil)
+	test.That(t, closed.classCounter[LabelDet0], test.ShouldEqual, 5)
+
+	// on Reconfigure, the counters are loaded from a new state file, or written to it if there is none
+	other := filepath.Join(dir, "other.json")
+	test.That(t, writeFileAtomic(other, []byte(`{"counters": {"cat": 7}}`)), test.ShouldBeNil)
+	test.That(t, closed.useCounterStateFile(other, morning.Add(time.Hour)), test.ShouldBeNil)
+	test.That(t, closed.classCounter[LabelDet0], test.ShouldEqual, 7)
+	fresh := filepath.Join(dir, "fresh.json")
+	test.That(t, closed.useCounterStateFile(fresh, morning.Add(time.Hour)), test.ShouldBeNil)
+	closed.saveCounters(closed.encodeCounters(morning.Add(time.Hour), false))
+	migrated := newFakeTracker()
+	migrated.counterStateFile = fresh
+	test.That(t, migrated.loadCounters(morning.Add(time.Hour)), test.ShouldBeNil)
+	test.That(t, migrated.classCounter[LabelDet0], test.ShouldEqual, 7)
+
+	// the counters are reset at the reset time, but numbers still in use are skipped
+	restarted.resetCountersIfDue(morning.Add(12 * time.Hour))
+	test.That(t, restarted.classCounter, test.ShouldResemble, map[string]int{LabelDet0: 3})
+	restarted.resetCountersIfDue(morning.Add(20 * time.Hour))
+	test.That(t, len(restarted.classCounter), test.ShouldEqual, 0)
+	restarted.tracks = map[string][]*track{LabelDet0 + "_0": {tr}}
+	tr = restarted.RenameFirstTime(newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit))
+	test.That(t, getTrackingLabel(tr), test.ShouldEqual, LabelDet0+"_1")
+
+	// a reset missed while the module was stopped is done when the counters are loaded
+	stopped := newFakeTracker()
+	test.That(t, stopped.loadCounters(morning.Add(48*time.Hour)), test.ShouldBeNil)
+	test.That(t, len(stopped.classCounter), test.ShouldEqual, 0)
+
+	_, err = parseDailyTime("25:00")
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = counterStatePath("counters.json", "tracker")
+	if os.Getenv(moduleDataEnv) == "" {
+		test.That(t, err, test.ShouldNotBeNil)
+	}
+	path, err := counterStatePath(filepath.Join(dir, "state.json"), "tracker")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, path, test.ShouldEqual, filepath.Join(dir, "state.json"))
+}
+
+func TestClassificationVoting(t *testing.T) {
+	_, err := newClassificationVoter("unanimous", 3, 2)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = newClassificationVoter(ClassificationPolicyMajority, 0, 2)
+	test.That(t, err, test.ShouldNotBeNil)
+
+	full := classification.NewClassification(0.9, FullPizzaLabel)
+	partial := classification.NewClassification(0.4, PartialPizzaLabel)
+	run := func(policy string, classifications ...classification.Classification) ([]string, *track) {
+		voter, err := newClassificationVoter(policy, 3, 2)
+		test.That(t, err, test.ShouldBeNil)
+		tr := &track{}
+		var out []string
+		for _, c := range classifications {
+			tr.classification = voter.vote(tr, c)
+			out = append(out, tr.classification)
+		}
+		return out, tr
+	}
+
+	out, _ := run(ClassificationPolicyLatest, full, partial, full)
+	test.That(t, out, test.ShouldResemble, []string{FullPizzaLabel, PartialPizzaLabel, FullPizzaLabel})
+	out, tr := run(ClassificationPolicyMajority, full, partial, partial, full)
+	test.That(t, out, test.ShouldResemble, []string{FullPizzaLabel, FullPizzaLabel, PartialPizzaLabel, PartialPizzaLabel})
+	test.That(t, len(tr.classVotes), test.ShouldEqual, 3)
+	test.That(t, tr.classificationVotes[PartialPizzaLabel], test.ShouldAlmostEqual, 2.0/3)
+	out, tr = run(ClassificationPolicyWeighted, full, partial, partial)
+	test.That(t, out, test.ShouldResemble, []string{FullPizzaLabel, FullPizzaLabel, FullPizzaLabel})
+	test.That(t, tr.classificationVotes[FullPizzaLabel], test.ShouldAlmostEqual, 0.9/1.7)
+	out, _ = run(ClassificationPolicyHysteresis, full, partial, full, partial, partial)
+	test.That(t, out, test.ShouldResemble, []string{FullPizzaLabel, FullPizzaLabel, FullPizzaLabel, FullPizzaLabel, PartialPizzaLabel})
+
+	// the history of the previous versions of a track is not modified
+	voter, err := newClassificationVoter(ClassificationPolicyMajority, 3, 2)
+	test.That(t, err, test.ShouldBeNil)
+	old := &track{}
+	voter.vote(old, full)
+	next := old.clone()
+	voter.vote(next, partial)
+	test.That(t, len(old.classVotes), test.ShouldEqual, 1)
+	test.That(t, len(next.classVotes), test.ShouldEqual, 2)
+
+	// the vote distribution is reported in the logs
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		classificationVoter:  voter,
+	}
+	named := newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit)
+	named.detClassification = full
+	named = fakeTracker.RenameFirstTime(named)
+	fakeTracker.allFreshObjects.add(newTrackedObject(named))
+	named.state = trackConfirmed
+	for i := 0; i < 2; i++ {
+		nextTrack := newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit)
+		nextTrack.detClassification = partial
+		named, _ = fakeTracker.UpdateTrack(nextTrack, named)
+	}
+	test.That(t, named.classification, test.ShouldEqual, PartialPizzaLabel)
+	logged := fakeTracker.allFreshObjects.list()[0]
+	test.That(t, logged.Classification, test.ShouldEqual, PartialPizzaLabel)
+	test.That(t, logged.FullLabel, test.ShouldEqual, named.Det.Label())
+	test.That(t, logged.ClassificationVotes[FullPizzaLabel], test.ShouldAlmostEqual, 1.0/3)
+}
+
+func TestClassificationTransitions(t *testing.T) {
+	for _, rule := range []ClassificationTransition{
+		{From: PartialPizzaLabel, Action: TransitionSplit},
+		{From: PartialPizzaLabel, To: FullPizzaLabel, Action: "merge"},
+		{From: FullPizzaLabel, To: PartialPizzaLabel, Action: TransitionEvent},
+	} {
+		test.That(t, rule.Validate(), test.ShouldNotBeNil)
+	}
+
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		classificationTransitions: append([]ClassificationTransition{
+			{From: FullPizzaLabel, To: PartialPizzaLabel, Action: TransitionEvent, Event: "first_slice_taken"},
+			{From: AllClasses, To: "burnt", Action: TransitionForbid},
+		}, DefaultClassificationTransitions...),
+	}
+	classified := func(label string) *track {
+		tr := newTrack(objdet.NewDetection(image.Rect(0, 0, 10, 10), 1, LabelDet0), TestPersistenceLimit)
+		tr.detClassification = classification.NewClassification(0.9, label)
+		return tr
+	}
+	named := fakeTracker.RenameFirstTime(classified(FullPizzaLabel))
+	named.state = trackConfirmed
+
+	// full to partial is allowed and logged with the label the track was output with
+	fullLabel := named.Det.Label()
+	named, _ = fakeTracker.UpdateTrack(classified(PartialPizzaLabel), named)
+	test.That(t, named.classification, test.ShouldEqual, PartialPizzaLabel)
+	events := fakeTracker.classificationEvents.list()
+	test.That(t, len(events), test.ShouldEqual, 1)
+	test.That(t, events[0].Label, test.ShouldEqual, fullLabel)
+	test.That(t, events[0].From, test.ShouldEqual, FullPizzaLabel)
+	test.That(t, events[0].To, test.ShouldEqual, PartialPizzaLabel)
+	test.That(t, events[0].Event, test.ShouldEqual, "first_slice_taken")
+
+	// forbidden classifications are ignored
+	named, _ = fakeTracker.UpdateTrack(classified("burnt"), named)
+	test.That(t, named.classification, test.ShouldEqual, PartialPizzaLabel)
+	test.That(t, len(fakeTracker.classificationEvents.list()), test.ShouldEqual, 1)
+
+	// a partial pizza matching a full pizza starts a new track
+	matches := []int{0}
+	newDets := []*track{classified(FullPizzaLabel)}
+	updated, newlyStable, notUsed := fakeTracker.updateMatchedTracks(matches, [][]float64{{1}}, []*track{named}, newDets,
+		map[int]struct{}{0: {}})
+	test.That(t, matches, test.ShouldResemble, []int{-1})
+	test.That(t, len(updated)+len(newlyStable), test.ShouldEqual, 0)
+	test.That(t, len(notUsed), test.ShouldEqual, 1)
+
+	// without the rule, the same detection continues the track
+	fakeTracker.classificationTransitions = nil
+	matches = []int{0}
+	updated, _, notUsed = fakeTracker.updateMatchedTracks(matches, [][]float64{{1}}, []*track{named}, newDets,
+		map[int]struct{}{0: {}})
+	test.That(t, len(updated), test.ShouldEqual, 1)
+	test.That(t, len(notUsed), test.ShouldEqual, 0)
+	test.That(t, updated[0].classification, test.ShouldEqual, FullPizzaLabel)
+
+	// the split applies to the voted classification: one flickering frame does not split the track
+	fakeTracker.classificationTransitions = DefaultClassificationTransitions
+	fakeTracker.classificationVoter, _ = newClassificationVoter(ClassificationPolicyMajority, 3, 1)
+	partial := fakeTracker.RenameFirstTime(classified(PartialPizzaLabel))
+	partial, _ = fakeTracker.UpdateTrack(classified(PartialPizzaLabel), partial)
+	partial.state = trackConfirmed
+	matches = []int{0}
+	updated, _, notUsed = fakeTracker.updateMatchedTracks(matches, [][]float64{{1}}, []*track{partial}, newDets,
+		map[int]struct{}{0: {}})
+	test.That(t, matches, test.ShouldResemble, []int{0})
+	test.That(t, len(updated), test.ShouldEqual, 1)
+	test.That(t, len(notUsed), test.ShouldEqual, 0)
+	test.That(t, updated[0].classification, test.ShouldEqual, PartialPizzaLabel)
+	test.That(t, getTrackingLabel(updated[0]), test.ShouldEqual, getTrackingLabel(partial))
+
+	// once the votes turn to full, the track is split
+	matches = []int{0}
+	_, _, notUsed = fakeTracker.updateMatchedTracks(matches, [][]float64{{1}}, []*track{updated[0]}, []*track{classified(FullPizzaLabel)},
+		map[int]struct{}{0: {}})
+	test.That(t, matches, test.ShouldResemble, []int{-1})
+	test.That(t, len(notUsed), test.ShouldEqual, 1)
+}
+
+func TestClassifyPolicy(t *testing.T) {
+	_, err := newClassifyPolicy("sometimes", 2, 0.3)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = newClassifyPolicy(ClassifyEveryNFrames, 0, 0.3)
+	test.That(t, err, test.ShouldNotBeNil)
+
+	calls := 0
+	classifier := &inject.VisionService{
+		ClassificationsFunc: func(ctx context.Context, img image.Image, n int, extra map[string]interface{}) (classification.Classifications, error) {
+			calls++
+			return []classification.Classification{classification.NewClassification(0.99, FullPizzaLabel)}, nil
+		},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	// a track is named, then seen in 4 more frames, it is confirmed on the 2nd one and moves on the 3rd one
+	boxes := []image.Rectangle{image.Rect(0, 0, 20, 20), image.Rect(1, 0, 21, 20), image.Rect(10, 10, 30, 30), image.Rect(10, 10, 30, 30)}
+	run := func(mode string) int {
+		policy, err := newClassifyPolicy(mode, 2, 0.3)
+		test.That(t, err, test.ShouldBeNil)
+		fakeTracker := &myTracker{
+			logger:               logging.NewTestLogger(t),
+			classCounter:         make(map[string]int),
+			tracks:               make(map[string][]*track),
+			lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+			allFreshObjects:      allObjects{objects: []trackedObject{}},
+			pizzaClassifier:      classifier,
+			classifyPolicy:       policy,
+		}
+		calls = 0
+		first := newTracks([]objdet.Detection{objdet.NewDetection(image.Rect(0, 0, 20, 20), 1, LabelDet0)}, TestPersistenceLimit)
+		fakeTracker.classifyDue(context.Background(), nil, nil, first, img)
+		tr := fakeTracker.RenameFirstTime(first[0])
+		for _, bb := range boxes {
+			next := newTracks([]objdet.Detection{objdet.NewDetection(bb, 1, LabelDet0)}, TestPersistenceLimit)
+			fakeTracker.classifyDue(context.Background(), []int{0}, []*track{tr}, next, img)
+			tr, _ = fakeTracker.UpdateTrack(next[0], tr)
+			if calls > 0 {
+				// the track keeps its classification between classifications
+				test.That(t, tr.classification, test.ShouldEqual, FullPizzaLabel)
+				test.That(t, tr.Det.Label(), test.ShouldEndWith, "_"+FullPizzaLabel)
+			}
+		}
+		return calls
+	}
+	test.That(t, run(ClassifyEveryFrame), test.ShouldEqual, 0)
+	test.That(t, run(ClassifyOnNewTrack), test.ShouldEqual, 1)
+	test.That(t, run(ClassifyOnStable), test.ShouldEqual, 1)
+	test.That(t, run(ClassifyEveryNFrames), test.ShouldEqual, 3)
+	test.That(t, run(ClassifyOnBoxChange), test.ShouldEqual, 2)
+
+	// the classifications made on demand still split the tracks: a full pizza replacing a stable partial pizza
+	// is classified as its box changed, and starts a new track
+	label := PartialPizzaLabel
+	policy, err := newClassifyPolicy(ClassifyOnBoxChange, 2, 0.3)
+	test.That(t, err, test.ShouldBeNil)
+	fakeTracker := &myTracker{
+		logger:               logging.NewTestLogger(t),
+		classCounter:         make(map[string]int),
+		tracks:               make(map[string][]*track),
+		lostDetectionsBuffer: newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:      allObjects{objects: []trackedObject{}},
+		pizzaClassifier: &inject.VisionService{
+			ClassificationsFunc: func(ctx context.Context, img image.Image, n int, extra map[string]interface{}) (classification.Classifications, error) {
+				return []classification.Classification{classification.NewClassification(0.99, label)}, nil
+			},
+		},
+		classifyPolicy:            policy,
+		classificationTransitions: DefaultClassificationTransitions,
+	}
+	partial := newTracks([]objdet.Detection{objdet.NewDetection(image.Rect(0, 0, 20, 20), 1, LabelDet0)}, TestPersistenceLimit)
+	fakeTracker.classifyDue(context.Background(), nil, nil, partial, img)
+	tr := fakeTracker.RenameFirstTime(partial[0])
+	tr.state = trackConfirmed
+	test.That(t, tr.classification, test.ShouldEqual, PartialPizzaLabel)
+	label = FullPizzaLabel
+	oldDets := []*track{tr}
+	newDets := newTracks([]objdet.Detection{objdet.NewDetection(image.Rect(8, 8, 28, 28), 1, LabelDet0)}, TestPersistenceLimit)
+	matchMtx := fakeTracker.BuildMatchingMatrix(oldDets, newDets)
+	HA, err := hg.NewHungarianAlgorithm(matchMtx)
+	test.That(t, err, test.ShouldBeNil)
+	matches := fakeTracker.GateMatches(HA.Execute(), matchMtx, oldDets)
+	test.That(t, matches, test.ShouldResemble, []int{0})
+	fakeTracker.classifyDue(context.Background(), matches, oldDets, newDets, img)
+	updated, newlyStable, fresh := fakeTracker.RenameFromMatches(matches, matchMtx, oldDets, newDets)
+	test.That(t, len(updated)+len(newlyStable), test.ShouldEqual, 0)
+	test.That(t, len(fresh), test.ShouldEqual, 1)
+	test.That(t, getTrackingLabel(fresh[0]), test.ShouldEqual, LabelDet0+"_1")
+	test.That(t, fresh[0].classification, test.ShouldEqual, FullPizzaLabel)
+
+	// the policies classifying each track once cannot split tracks
+	once, err := newClassifyPolicy(ClassifyOnStable, 2, 0.3)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, once.checkTransitions(DefaultClassificationTransitions), test.ShouldNotBeNil)
+	test.That(t, once.checkTransitions([]ClassificationTransition{{From: AllClasses, To: "burnt", Action: TransitionForbid}}), test.ShouldBeNil)
+	test.That(t, policy.checkTransitions(DefaultClassificationTransitions), test.ShouldBeNil)
+
+	stages := newStageStats()
+	stages.add(stageClassify, 3*time.Millisecond)
+	stages.add("unknown", time.Millisecond)
+	bench := stages.benchmarks()
+	test.That(t, len(bench), test.ShouldEqual, 3)
+	test.That(t, bench[stageClassify].NumberOfRuns, test.ShouldEqual, 1)
+	test.That(t, bench[stageDetect].NumberOfRuns, test.ShouldEqual, 0)
+}
+
+func TestClassifierPool(t *testing.T) {
+	var running, maxRunning, calls atomic.Int32
+	classifier := &inject.VisionService{
+		ClassificationsFunc: func(ctx context.Context, img image.Image, n int, extra map[string]interface{}) (classification.Classifications, error) {
+			calls.Add(1)
+			if r := running.Add(1); r > maxRunning.Load() {
+				maxRunning.Store(r)
+			}
+			defer running.Add(-1)
+			switch img.Bounds().Dx() {
+			case 13:
+				return nil, errors.New("classifier failure")
+			case 17:
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			time.Sleep(10 * time.Millisecond)
+			return []classification.Classification{classification.NewClassification(0.9, fmt.Sprintf("w%d", img.Bounds().Dx()))}, nil
+		},
+	}
+	fakeTracker := &myTracker{
+		logger:                logging.NewTestLogger(t),
+		classCounter:          make(map[string]int),
+		tracks:                make(map[string][]*track),
+		lostDetectionsBuffer:  newTracksBuffer(DefaultBufferSize),
+		allFreshObjects:       allObjects{objects: []trackedObject{}},
+		pizzaClassifier:       classifier,
+		classifierConcurrency: 3,
+		classifierTimeout:     50 * time.Millisecond,
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	var dets []objdet.Detection
+	for _, w := range []int{10, 11, 13, 17, 20, 21, 22} {
+		dets = append(dets, objdet.NewDetection(image.Rect(0, 0, w, 10), 1, LabelDet0))
+	}
+	start := time.Now()
+	results := fakeTracker.classifyDetections(context.Background(), dets, img)
+	test.That(t, time.Since(start), test.ShouldBeLessThan, time.Second)
+	test.That(t, int(calls.Load()), test.ShouldEqual, 7)
+	test.That(t, int(maxRunning.Load()), test.ShouldBeLessThanOrEqualTo, 3)
+	test.That(t, len(results), test.ShouldEqual, 7)
+	for i, w := range []int{10, 11, 13, 17, 20, 21, 22} {
+		if w == 13 || w == 17 {
+			// failed and timed out crops are not classified
+			test.That(t, results[i], test.ShouldBeNil)
+			continue
+		}
+		test.That(t, results[i].Label(), test.ShouldEqual, fmt.Sprintf("w%d", w))
+	}
+
+	// in mosaic mode, all the crops are sent at once and each tile gets the detection centered in it
+	mosaic, tiles := buildMosaic(dets[:5], img, nil)
+	test.That(t, mosaic.Bounds(), test.ShouldResemble, image.Rect(0, 0, 3*20, 2*10))
+	test.That(t, tiles[4], test.ShouldResemble, image.Rect(20, 10, 40, 20))
+	mosaicCalls := 0
+	fakeTracker.pizzaClassifier = &inject.VisionService{
+		DetectionsFunc: func(ctx context.Context, img image.Image, extra map[string]interface{}) ([]objdet.Detection, error) {
+			mosaicCalls++
+			return []objdet.Detection{
+				objdet.NewDetection(image.Rect(0, 0, 10, 10), 0.6, PartialPizzaLabel),
+				objdet.NewDetection(image.Rect(1, 1, 9, 9), 0.8, FullPizzaLabel),
+				objdet.NewDetection(image.Rect(22, 12, 38, 18), 0.7, PartialPizzaLabel),
+			}, nil
+		},
+	}
+	fakeTracker.classifierMosaic = true
+	results = fakeTracker.classifyDetections(context.Background(), dets[:5], img)
+	test.That(t, mosaicCalls, test.ShouldEqual, 1)
+	test.That(t, results[0].Label(), test.ShouldEqual, FullPizzaLabel)
+	test.That(t, results[4].Label(), test.ShouldEqual, PartialPizzaLabel)
+	for _, i := range []int{1, 2, 3} {
+		test.That(t, results[i], test.ShouldBeNil)
+	}
+
+	// a classifier that does not return detections cannot be used in mosaic mode
+	classificationOnly := &inject.VisionService{
+		GetPropertiesFunc: func(ctx context.Context, extra map[string]interface{}) (*vision.Properties, error) {
+			return &vision.Properties{ClassificationSupported: true}, nil
+		},
+	}
+	test.That(t, checkMosaicClassifier(context.Background(), classificationOnly), test.ShouldNotBeNil)
+	detector := &inject.VisionService{
+		GetPropertiesFunc: func(ctx context.Context, extra map[string]interface{}) (*vision.Properties, error) {
+			return &vision.Properties{ClassificationSupported: true, DetectionSupported: true}, nil
+		},
+	}
+	test.That(t, checkMosaicClassifier(context.Background(), detector), test.ShouldBeNil)
+}
+
+func TestClassifierCrop(t *testing.T) {
+	_, err := newCropper(&CropOptions{PaddingPixels: -1}, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldNotBeNil)
+	c, err := newCropper(nil, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, c, test.ShouldBeNil)
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	det := objdet.NewDetection(image.Rect(90, 40, 100, 60), 1, LabelDet0)
+	white := color.RGBA{255, 255, 255, 255}
+	black := color.RGBA{0, 0, 0, 255}
+	crop := func(options CropOptions) image.Image {
+		c, err := newCropper(&options, logging.NewTestLogger(t))
+		test.That(t, err, test.ShouldBeNil)
+		return c.crop(img, det)
+	}
+
+	test.That(t, c.crop(img, det).Bounds(), test.ShouldResemble, image.Rect(0, 0, 10, 20))
+	// without options, the box is clamped to the image too
+	outside := objdet.NewDetection(image.Rect(90, 40, 110, 60), 1, LabelDet0)
+	test.That(t, cropImageFromDet(img, outside).Bounds(), test.ShouldResemble, image.Rect(0, 0, 10, 20))
+	test.That(t, cropImageFromDet(img, outside).At(0, 0), test.ShouldResemble, color.RGBA{255, 255, 255, 255})
+	// the padding is clamped to the image by default
+	test.That(t, crop(CropOptions{PaddingPixels: 5}).Bounds(), test.ShouldResemble, image.Rect(0, 0, 15, 30))
+	test.That(t, crop(CropOptions{PaddingFraction: 0.5}).Bounds(), test.ShouldResemble, image.Rect(0, 0, 15, 40))
+	clamp := false
+	out := crop(CropOptions{PaddingPixels: 5, Clamp: &clamp})
+	test.That(t, out.Bounds(), test.ShouldResemble, image.Rect(0, 0, 20, 30))
+	test.That(t, out.At(0, 0), test.ShouldResemble, white)
+	test.That(t, out.At(19, 0), test.ShouldResemble, black)
+	// letterboxed to a square, the crop is centered between black bars
+	out = crop(CropOptions{PaddingPixels: 5, Square: true})
+	test.That(t, out.Bounds(), test.ShouldResemble, image.Rect(0, 0, 30, 30))
+	test.That(t, out.At(0, 15), test.ShouldResemble, black)
+	test.That(t, out.At(15, 15), test.ShouldResemble, white)
+	test.That(t, crop(CropOptions{Square: true, Size: 16}).Bounds(), test.ShouldResemble, image.Rect(0, 0, 16, 16))
+
+	// one crop out of DumpEveryN is saved
+	dir := filepath.Join(t.TempDir(), "crops")
+	c, err = newCropper(&CropOptions{DumpDir: dir, DumpEveryN: 2}, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	for i := 0; i < 3; i++ {
+		c.crop(img, det)
+	}
+	// the crops are saved in the background
+	c.wait()
+	dumped, err := filepath.Glob(filepath.Join(dir, LabelDet0+"_*.png"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(dumped), test.ShouldEqual, 2)
+
+	// a reconfiguration waits for the crops of the old cropper, which saves no more crops afterwards
+	large := image.NewRGBA(image.Rect(0, 0, 1000, 1000))
+	whole := objdet.NewDetection(large.Bounds(), 1, LabelDet1)
+	oldDir := filepath.Join(t.TempDir(), "old")
+	fakeTracker := &myTracker{logger: logging.NewTestLogger(t)}
+	test.That(t, fakeTracker.useCropper(&CropOptions{DumpDir: oldDir, DumpEveryN: 1}), test.ShouldBeNil)
+	old := fakeTracker.cropper
+	for i := 0; i < 3; i++ {
+		old.crop(large, whole)
+	}
+	newDir := filepath.Join(t.TempDir(), "new")
+	test.That(t, fakeTracker.useCropper(&CropOptions{DumpDir: newDir, DumpEveryN: 1}), test.ShouldBeNil)
+	dumped, err = filepath.Glob(filepath.Join(oldDir, "*.png"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(dumped), test.ShouldEqual, 3)
+	old.crop(large, whole)
+	old.wait()
+	dumped, err = filepath.Glob(filepath.Join(oldDir, "*.png"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(dumped), test.ShouldEqual, 3)
+	test.That(t, fakeTracker.cropper, test.ShouldNotEqual, old)
+	test.That(t, fakeTracker.cropper.options.DumpDir, test.ShouldEqual, newDir)
+}
@@ -0,0 +1,164 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains methods that expose the stored history of the tracks
+package tracker
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// trackPoint is one bounding box in the history of a track
+type trackPoint struct {
+	Time           time.Time
+	XMin           int
+	YMin           int
+	XMax           int
+	YMax           int
+	Score          float64
+	Classification string
+}
+
+// trackInfo describes a track and its trajectory, as returned by DoCommand
+type trackInfo struct {
+	Label                 string
+	State                 string
+	PersistenceCount      int
+	FirstSeen             time.Time
+	LastSeen              time.Time
+	ClassificationHistory []string
+	History               []trackPoint `json:",omitempty"`
+	// ClassificationVotes is the share of the votes of each classification over the voting window
+	ClassificationVotes map[string]float64 `json:",omitempty"`
+}
+
+// trackFilter restricts the tracks returned by list_tracks
+type trackFilter struct {
+	state          string
+	class          string
+	includeHistory bool
+}
+
+// parseTrackFilter reads the options of the list_tracks command: "state", "class" and "include_history"
+func parseTrackFilter(arg interface{}) (trackFilter, error) {
+	filter := trackFilter{includeHistory: true}
+	if arg == nil {
+		return filter, nil
+	}
+	opts, ok := arg.(map[string]interface{})
+	if !ok {
+		// list_tracks can also be called with any non-map value, e.g. true
+		return filter, nil
+	}
+	if v, ok := opts["state"]; ok {
+		if filter.state, ok = v.(string); !ok {
+			return filter, errors.New("list_tracks option state must be a string")
+		}
+	}
+	if v, ok := opts["class"]; ok {
+		class, ok := v.(string)
+		if !ok {
+			return filter, errors.New("list_tracks option class must be a string")
+		}
+		filter.class = strings.ToLower(class)
+	}
+	if v, ok := opts["include_history"]; ok {
+		if filter.includeHistory, ok = v.(bool); !ok {
+			return filter, errors.New("list_tracks option include_history must be a boolean")
+		}
+	}
+	return filter, nil
+}
+
+// newTrackInfo builds the description of a track from its stored history
+func newTrackInfo(history []*track, includeHistory bool) trackInfo {
+	last := history[len(history)-1]
+	info := trackInfo{
+		Label:               last.Det.Label(),
+		State:               last.state.String(),
+		PersistenceCount:    last.persistenceCount,
+		FirstSeen:           last.firstSeen,
+		LastSeen:            last.seenAt,
+		ClassificationVotes: last.classificationVotes,
+	}
+	if info.FirstSeen.IsZero() {
+		info.FirstSeen = history[0].seenAt
+	}
+	for _, tr := range history {
+		classification := tr.classification
+		n := len(info.ClassificationHistory)
+		if classification != "" && (n == 0 || info.ClassificationHistory[n-1] != classification) {
+			info.ClassificationHistory = append(info.ClassificationHistory, classification)
+		}
+		if includeHistory {
+			bb := tr.Det.BoundingBox()
+			info.History = append(info.History, trackPoint{
+				Time:           tr.seenAt,
+				XMin:           bb.Min.X,
+				YMin:           bb.Min.Y,
+				XMax:           bb.Max.X,
+				YMax:           bb.Max.Y,
+				Score:          tr.Det.Score(),
+				Classification: classification,
+			})
+		}
+	}
+	return info
+}
+
+// getTrack returns the description of the track with the given label. The label can either be any label
+// the detections of the track were output with, or only its class and counter (e.g. "pizza_3").
+func (t *myTracker) getTrack(label string) (trackInfo, error) {
+	t.tracksMutex.RLock()
+	defer t.tracksMutex.RUnlock()
+	history, ok := t.tracks[label]
+	if !ok {
+		history, ok = t.findTrack(label)
+	}
+	if !ok || len(history) == 0 {
+		return trackInfo{}, errors.Errorf("no track with label %v", label)
+	}
+	return newTrackInfo(history, true), nil
+}
+
+// findTrack returns the history of the track that was output with the label, looking at the latest labels first
+func (t *myTracker) findTrack(label string) ([]*track, bool) {
+	for _, history := range t.tracks {
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Det.Label() == label {
+				return history, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// listTracks returns the description of all the tracks kept in memory that match the filter, sorted by label
+func (t *myTracker) listTracks(filter trackFilter) []trackInfo {
+	t.tracksMutex.RLock()
+	defer t.tracksMutex.RUnlock()
+	labels := make([]string, 0, len(t.tracks))
+	for label := range t.tracks {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	out := make([]trackInfo, 0, len(labels))
+	for _, label := range labels {
+		history := t.tracks[label]
+		if len(history) == 0 {
+			continue
+		}
+		last := history[len(history)-1]
+		if filter.state != "" && last.state.String() != filter.state {
+			continue
+		}
+		if filter.class != "" && last.class != filter.class {
+			continue
+		}
+		out = append(out, newTrackInfo(history, filter.includeHistory))
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the rules applied when the classification of a track changes
+package tracker
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Actions of the classification transitions, as given in the config
+const (
+	// TransitionSplit starts a new track when a stable track matches a detection with the new classification
+	TransitionSplit = "split"
+	// TransitionForbid keeps the classification of the track, the new classification is ignored
+	TransitionForbid = "forbid"
+	// TransitionEvent logs an event when the classification of the track changes
+	TransitionEvent = "event"
+)
+
+// ClassificationTransition is a rule for the change of classification of a track. From and To can be
+// AllClasses to match any classification.
+type ClassificationTransition struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Action string `json:"action"`
+	// Event is the name of the event logged with TransitionEvent, e.g. "first_slice_taken"
+	Event string `json:"event,omitempty"`
+}
+
+// DefaultClassificationTransitions is the rule used when none is configured: a stable partial pizza
+// matching a full pizza is a new pizza
+var DefaultClassificationTransitions = []ClassificationTransition{
+	{From: PartialPizzaLabel, To: FullPizzaLabel, Action: TransitionSplit},
+}
+
+// Validate checks the transition
+func (c *ClassificationTransition) Validate() error {
+	if c.From == "" || c.To == "" {
+		return errors.New("classification transitions must have a from and a to classification")
+	}
+	switch c.Action {
+	case TransitionSplit, TransitionForbid:
+	case TransitionEvent:
+		if c.Event == "" {
+			return errors.Errorf("classification transition from %v to %v must have an event name", c.From, c.To)
+		}
+	default:
+		return errors.Errorf("unknown action %q of classification transition from %v to %v, must be %q, %q or %q",
+			c.Action, c.From, c.To, TransitionSplit, TransitionForbid, TransitionEvent)
+	}
+	return nil
+}
+
+func (c *ClassificationTransition) matches(from, to string) bool {
+	return (c.From == AllClasses || c.From == from) && (c.To == AllClasses || c.To == to)
+}
+
+// findTransition returns the first rule with the action that matches the change of classification, if any
+func findTransition(rules []ClassificationTransition, action, from, to string) (ClassificationTransition, bool) {
+	if from == "" || to == "" || from == to {
+		return ClassificationTransition{}, false
+	}
+	for _, rule := range rules {
+		if rule.Action == action && rule.matches(from, to) {
+			return rule, true
+		}
+	}
+	return ClassificationTransition{}, false
+}
+
+// splitsTrack returns whether a stable track with a classification cannot be matched with a new detection
+// with another classification, the detection then starts a new track. The rules apply to the classification
+// the track would be voted to, so that one flickering frame does not split the track.
+func (t *myTracker) splitsTrack(old, next *track) bool {
+	if !old.isStable() || next.detClassification == nil {
+		return false
+	}
+	// the vote is cast on a copy, the old track is only updated if it is not split
+	classification := t.classificationVoter.vote(old.clone(), next.detClassification)
+	_, ok := findTransition(t.classificationTransitions, TransitionSplit, old.classification, classification)
+	return ok
+}
+
+// applyTransitions returns the classification of the track after it was voted to change to the new one:
+// the classification is kept if the change is forbidden, and an event is logged if the rules ask for one
+func (t *myTracker) applyTransitions(tr *track, classification string) string {
+	from := tr.classification
+	if _, ok := findTransition(t.classificationTransitions, TransitionForbid, from, classification); ok {
+		return from
+	}
+	if rule, ok := findTransition(t.classificationTransitions, TransitionEvent, from, classification); ok {
+		t.classificationEvents.add(trackEvent{
+			Label: tr.Det.Label(),
+			From:  from,
+			To:    classification,
+			Time:  time.Now(),
+			Event: rule.Event,
+		})
+	}
+	return classification
+}
@@ -0,0 +1,142 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the policies that choose the classification of a track from its classification history
+package tracker
+
+import (
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/vision/classification"
+)
+
+// Classification policies, as given in the config
+const (
+	// ClassificationPolicyLatest uses the classification of the latest frame
+	ClassificationPolicyLatest = "latest"
+	// ClassificationPolicyMajority uses the most frequent classification over the window
+	ClassificationPolicyMajority = "majority"
+	// ClassificationPolicyWeighted uses the classification with the highest average confidence over the window
+	ClassificationPolicyWeighted = "weighted"
+	// ClassificationPolicyHysteresis only switches to a new classification once it was given on consecutive frames
+	ClassificationPolicyHysteresis = "hysteresis"
+)
+
+// DefaultClassificationWindow is the default number of classifications of a track that are voted on
+var DefaultClassificationWindow = 10
+
+// DefaultClassificationHysteresis is the default number of consecutive frames needed to switch classification
+var DefaultClassificationHysteresis = 3
+
+// classVote is the classification of a track on one frame
+type classVote struct {
+	label string
+	score float64
+}
+
+// classificationVoter chooses the classification of the tracks according to the policy
+type classificationVoter struct {
+	policy     string
+	window     int
+	hysteresis int
+}
+
+func newClassificationVoter(policy string, window, hysteresis int) (*classificationVoter, error) {
+	switch policy {
+	case "":
+		policy = ClassificationPolicyLatest
+	case ClassificationPolicyLatest, ClassificationPolicyMajority, ClassificationPolicyWeighted, ClassificationPolicyHysteresis:
+	default:
+		return nil, errors.Errorf("unknown classification_policy %q, must be %q, %q, %q or %q", policy,
+			ClassificationPolicyLatest, ClassificationPolicyMajority, ClassificationPolicyWeighted, ClassificationPolicyHysteresis)
+	}
+	if window < 1 {
+		return nil, errors.New("classification_window must be at least 1")
+	}
+	if hysteresis < 1 {
+		return nil, errors.New("classification_hysteresis must be at least 1")
+	}
+	return &classificationVoter{policy: policy, window: window, hysteresis: hysteresis}, nil
+}
+
+// vote adds the classification of the latest frame to the history of the track, updates its vote distribution
+// and returns the classification chosen by the policy. A nil voter uses the latest classification.
+func (v *classificationVoter) vote(tr *track, c classification.Classification) string {
+	if v == nil {
+		v = &classificationVoter{policy: ClassificationPolicyLatest, window: 1, hysteresis: 1}
+	}
+	// the history is copied as it is shared with the previous versions of the track
+	start := 0
+	if len(tr.classVotes) >= v.window {
+		start = len(tr.classVotes) - v.window + 1
+	}
+	votes := make([]classVote, 0, len(tr.classVotes[start:])+1)
+	votes = append(votes, tr.classVotes[start:]...)
+	tr.classVotes = append(votes, classVote{label: c.Label(), score: c.Score()})
+	tr.classificationVotes = v.distribution(tr.classVotes)
+
+	switch v.policy {
+	case ClassificationPolicyMajority, ClassificationPolicyWeighted:
+		return v.best(tr.classificationVotes, tr.classVotes, tr.classification)
+	case ClassificationPolicyHysteresis:
+		switch {
+		case tr.classification == "" || c.Label() == tr.classification:
+			tr.pendingClassification, tr.pendingCount = "", 0
+			return c.Label()
+		case c.Label() == tr.pendingClassification:
+			tr.pendingCount++
+		default:
+			tr.pendingClassification, tr.pendingCount = c.Label(), 1
+		}
+		if tr.pendingCount < v.hysteresis {
+			return tr.classification
+		}
+		tr.pendingClassification, tr.pendingCount = "", 0
+		return c.Label()
+	default:
+		return c.Label()
+	}
+}
+
+// distribution returns the share of the votes of each classification. With ClassificationPolicyWeighted,
+// each vote counts as much as its confidence.
+func (v *classificationVoter) distribution(votes []classVote) map[string]float64 {
+	out := make(map[string]float64)
+	total := 0.0
+	for _, vote := range votes {
+		w := 1.0
+		if v.policy == ClassificationPolicyWeighted {
+			w = vote.score
+		}
+		out[vote.label] += w
+		total += w
+	}
+	if total > 0 {
+		for label := range out {
+			out[label] /= total
+		}
+	}
+	return out
+}
+
+// best returns the classification with the largest share of the votes. Ties are won by the current
+// classification, then by the most recent vote.
+func (v *classificationVoter) best(shares map[string]float64, votes []classVote, current string) string {
+	best := ""
+	for i := len(votes) - 1; i >= 0; i-- {
+		label := votes[i].label
+		if best == "" || shares[label] > shares[best] {
+			best = label
+		}
+	}
+	if _, ok := shares[current]; ok && shares[current] >= shares[best] {
+		return current
+	}
+	return best
+}
+
+// logClassification updates the classification and the vote distribution in the logs of a stable track
+func (t *myTracker) logClassification(tr *track) {
+	t.updateLog(getTrackingLabel(tr), func(obj *trackedObject) {
+		obj.FullLabel = tr.Det.Label()
+		obj.Classification = tr.classification
+		obj.ClassificationVotes = tr.classificationVotes
+	})
+}
@@ -0,0 +1,235 @@
+// Package tracker implements an object tracker as a Viam vision service
+// This file contains the monitoring of the tracks entering and leaving zones of the image
+package tracker
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of zone events
+const (
+	ZoneEnter = "enter"
+	ZoneExit  = "exit"
+)
+
+// maxZoneEvents is the number of zone events kept in memory
+const maxZoneEvents = 1000
+
+// Zone is a named polygon of the image. A track is in a zone when the center of its bounding box is.
+type Zone struct {
+	Name string `json:"name"`
+	// Points are the [x, y] vertices of the polygon, in pixels or, if Normalized, as fractions of the image size
+	Points     [][]float64 `json:"points"`
+	Normalized bool        `json:"normalized,omitempty"`
+}
+
+func (z *Zone) validate() error {
+	if z.Name == "" {
+		return errors.New("zones must have a name")
+	}
+	if len(z.Points) < 3 {
+		return errors.Errorf("zone %v must have at least 3 points", z.Name)
+	}
+	for _, p := range z.Points {
+		if len(p) != 2 {
+			return errors.Errorf("points of zone %v must be [x, y] points", z.Name)
+		}
+		if z.Normalized && (p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1) {
+			return errors.Errorf("normalized coordinates of zone %v must be between 0.0 and 1.0", z.Name)
+		}
+	}
+	return nil
+}
+
+// contains returns whether the point is inside the polygon, using the even-odd rule
+func (z *Zone) contains(p point, bounds image.Rectangle) bool {
+	inside := false
+	for i, j := 0, len(z.Points)-1; i < len(z.Points); j, i = i, i+1 {
+		a := toPixels(z.Points[i], z.Normalized, bounds)
+		b := toPixels(z.Points[j], z.Normalized, bounds)
+		if (a.y > p.y) != (b.y > p.y) && p.x < (b.x-a.x)*(p.y-a.y)/(b.y-a.y)+a.x {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// zoneEvent is logged every time a track enters or leaves a zone
+type zoneEvent struct {
+	Zone string
+	// Label is the label the track was output with
+	Label string
+	Type  string
+	Time  time.Time
+	// DwellS is the time spent in the zone in seconds, set when the track leaves it
+	DwellS float64 `json:",omitempty"`
+	// key is the tracking label of the track
+	key string
+}
+
+// zoneStatus describes the tracks currently in a zone
+type zoneStatus struct {
+	Occupancy        int
+	OccupancyByClass map[string]int
+	// time (in seconds) each track (by output label) has been in the zone since it entered it
+	DwellS map[string]float64
+}
+
+type zoneMonitor struct {
+	mutex sync.RWMutex
+	zones []Zone
+	// time at which each track (by tracking label) entered each zone it is in, the class of the track
+	// and its latest output label
+	entered map[string]map[string]time.Time
+	classes map[string]string
+	labels  map[string]string
+	events  []zoneEvent
+}
+
+func newZoneMonitor(zones []Zone) *zoneMonitor {
+	return &zoneMonitor{
+		zones:   zones,
+		entered: make(map[string]map[string]time.Time),
+		classes: make(map[string]string),
+		labels:  make(map[string]string),
+	}
+}
+
+func (m *zoneMonitor) addEvent(event zoneEvent) {
+	if len(m.events) >= maxZoneEvents {
+		m.events = m.events[1:]
+	}
+	m.events = append(m.events, event)
+}
+
+// update moves the track with the tracking label key to the zones that contain the point, and returns the exit events.
+// The events report the label the track is output with.
+func (m *zoneMonitor) update(key, label, class string, p point, bounds image.Rectangle, ts time.Time) []zoneEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.entered[key]; ok {
+		m.labels[key] = label
+	}
+	var exits []zoneEvent
+	for i := range m.zones {
+		z := &m.zones[i]
+		enteredAt, wasIn := m.entered[key][z.Name]
+		isIn := z.contains(p, bounds)
+		switch {
+		case isIn && !wasIn:
+			if m.entered[key] == nil {
+				m.entered[key] = make(map[string]time.Time)
+				m.classes[key] = class
+				m.labels[key] = label
+			}
+			m.entered[key][z.Name] = ts
+			m.addEvent(zoneEvent{Zone: z.Name, Label: label, Type: ZoneEnter, Time: ts, key: key})
+		case !isIn && wasIn:
+			exits = append(exits, m.exit(key, z.Name, enteredAt, ts))
+		}
+	}
+	return exits
+}
+
+// exit removes the track from the zone, and logs and returns the exit event
+func (m *zoneMonitor) exit(key, zone string, enteredAt, ts time.Time) zoneEvent {
+	event := zoneEvent{Zone: zone, Label: m.labels[key], Type: ZoneExit, Time: ts, DwellS: ts.Sub(enteredAt).Seconds(), key: key}
+	delete(m.entered[key], zone)
+	if len(m.entered[key]) == 0 {
+		delete(m.entered, key)
+		delete(m.classes, key)
+		delete(m.labels, key)
+	}
+	m.addEvent(event)
+	return event
+}
+
+// leaveAll removes the track with the tracking label key from all the zones it is in, and returns the exit events
+func (m *zoneMonitor) leaveAll(key string, ts time.Time) []zoneEvent {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var exits []zoneEvent
+	for _, z := range m.zones {
+		if enteredAt, ok := m.entered[key][z.Name]; ok {
+			exits = append(exits, m.exit(key, z.Name, enteredAt, ts))
+		}
+	}
+	return exits
+}
+
+// status returns the occupancy of every zone
+func (m *zoneMonitor) status(now time.Time) map[string]zoneStatus {
+	out := make(map[string]zoneStatus)
+	if m == nil {
+		return out
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, z := range m.zones {
+		out[z.Name] = zoneStatus{OccupancyByClass: map[string]int{}, DwellS: map[string]float64{}}
+	}
+	for key, zones := range m.entered {
+		class := m.classes[key]
+		for zone, enteredAt := range zones {
+			status := out[zone]
+			status.Occupancy++
+			status.OccupancyByClass[class]++
+			status.DwellS[m.labels[key]] = now.Sub(enteredAt).Seconds()
+			out[zone] = status
+		}
+	}
+	return out
+}
+
+// list returns a copy of the stored events
+func (m *zoneMonitor) list() []zoneEvent {
+	if m == nil {
+		return []zoneEvent{}
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]zoneEvent{}, m.events...)
+}
+
+// updateZones computes the zones the stable tracks are in, from the center of their latest bounding box
+func (t *myTracker) updateZones(tracks []*track, bounds image.Rectangle) {
+	if t.zoneMonitor == nil || len(t.zoneMonitor.zones) == 0 {
+		return
+	}
+	for _, tr := range tracks {
+		if !tr.isStable() {
+			continue
+		}
+		exits := t.zoneMonitor.update(getTrackingLabel(tr), tr.Det.Label(), tr.class, boxCenter(tr.Det.BoundingBox()), bounds, tr.seenAt)
+		for _, event := range exits {
+			t.logZoneDwell(event)
+		}
+	}
+}
+
+// leaveZones removes a deleted track from the zones it is in, as of the last time it was seen
+func (t *myTracker) leaveZones(tr *track) {
+	for _, event := range t.zoneMonitor.leaveAll(getTrackingLabel(tr), tr.seenAt) {
+		t.logZoneDwell(event)
+	}
+}
+
+// logZoneDwell adds the time a track spent in a zone to the logs of the tracked object
+func (t *myTracker) logZoneDwell(event zoneEvent) {
+	t.updateLog(event.key, func(obj *trackedObject) {
+		// the map is copied so that the logs already returned by DoCommand are not modified
+		dwell := make(map[string]float64, len(obj.ZoneDwellS)+1)
+		for zone, s := range obj.ZoneDwellS {
+			dwell[zone] = s
+		}
+		dwell[event.Zone] += event.DwellS
+		obj.ZoneDwellS = dwell
+	})
+}